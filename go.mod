@@ -1,3 +1,5 @@
 module github.com/shermp/go-fbink-v2/v2
 
-go 1.13
+go 1.18
+
+require golang.org/x/image v0.18.0
@@ -0,0 +1,59 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+// Backend is what an FBInk session draws through. The methods map to the
+// FBInk functions of the same name (see "fbink.h"), and report failures
+// with the same errors.
+// The default backend (see New) wraps libfbink, NewVirtualFB provides a
+// pure Go one drawing to memory, which is handy to test UI code on
+// machines that aren't eInk devices, and NewFBDev drives a Linux
+// framebuffer device directly from Go.
+// Functions that only make sense on a real device (progress bars, pens,
+// OT font loading, Kobo USBMS helpers...) are only available through
+// libfbink, and fail with ErrNotImplemented on other backends.
+// Backends implemented outside of this package create their dumps with
+// NewDump, and get their pixels back in Restore with FBInkDump.Data.
+type Backend interface {
+	Open() error
+	Close() error
+	Init(cfg *FBInkConfig) error
+	ReInit(cfg *FBInkConfig) (ReinitChange, error)
+	State(cfg *FBInkConfig, state *FBInkState)
+	Print(str string, cfg *FBInkConfig) (int, error)
+	PrintOT(str string, otCfg *FBInkOTConfig, cfg *FBInkConfig) (int, FBInkOTFit, error)
+	PrintImage(path string, x, y int16, cfg *FBInkConfig) error
+	PrintRawData(data []byte, w, h int, x, y int16, cfg *FBInkConfig) error
+	ClearScreen(cfg *FBInkConfig, rect *FBInkRect) error
+	GridClear(cols, rows uint16, cfg *FBInkConfig) error
+	Refresh(top, left, width, height uint32, cfg *FBInkConfig) error
+	GridRefresh(cols, rows uint16, cfg *FBInkConfig) error
+	WaitForSubmission(marker uint32) error
+	WaitForCompletion(marker uint32) error
+	LastMarker() uint32
+	LastRect() FBInkRect
+	Dump() (*FBInkDump, error)
+	RegionDump(x, y int16, w, h uint16, cfg *FBInkConfig) (*FBInkDump, error)
+	Restore(dump *FBInkDump, cfg *FBInkConfig) error
+}
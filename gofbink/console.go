@@ -0,0 +1,550 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+	"unicode/utf8"
+)
+
+// ConsoleConfig defines the region of the screen a Console prints to,
+// in text cells
+type ConsoleConfig struct {
+	Row        int16   // First row
+	Rows       int16   // Height, in rows. 0 extends the console to the bottom of the screen (or the footers)
+	Col        int16   // First column
+	Cols       int16   // Width, in columns. 0 extends the console to the right edge
+	MaxLines   int     // Amount of (latest) lines shown. 0 shows as many as fit
+	Scrollback int     // Amount of rows kept around, above the view, for paging back. 0 disables paging
+	FGcolor    FGcolor // Colors, which require libfbink (FGblack & BGwhite keep the session's pens)
+	BGcolor    BGcolor
+}
+
+// DefaultConsoleConfig is the layout of the session's default console,
+// used by FBInk.Println & FBInk.PrintLastLn
+var DefaultConsoleConfig = ConsoleConfig{Row: 4, MaxLines: 9, Scrollback: 100}
+
+// lineStyle is how a line is rendered, on top of the console's colors
+type lineStyle struct {
+	inverted bool
+	bold     bool // In the bold variant of the session's font, or highlighted
+	dim      bool // In gray
+}
+
+type consoleLine struct {
+	text  string
+	style lineStyle
+	rows  []string // Wrapped rows, as cellText
+}
+
+// consoleRow is a row of the region, as drawn
+type consoleRow struct {
+	text  string
+	style lineStyle
+}
+
+// boldFonts maps fonts to their bold variant, which shares their metrics
+var boldFonts = map[Font]Font{
+	Orp:         OrpB,
+	Scientifica: ScientificaB,
+	Terminus:    TerminusB,
+	Tewi:        TewiB,
+}
+
+// Console prints lines to a region of the screen, in the manner of
+// fmt.Println, scrolling the older ones up as needed.
+// With a Scrollback, the lines that scrolled off can be paged back to.
+// While the view is at the bottom, the console follows new lines. Once
+// paged back, the view stays put until it is brought back to the bottom
+// (by PageDown, End or Search), where following resumes.
+// The console keeps track of what it shows, and only redraws the rows that
+// changed, with a single refresh covering them all.
+// Several independent consoles can share a session, as long as their
+// regions don't overlap.
+type Console struct {
+	f        *FBInk
+	cc       ConsoleConfig
+	lines    []consoleLine
+	offset   int          // Rows the view is scrolled back by, 0 when following
+	shown    []consoleRow // What's currently on screen, one entry per row
+	wrapCols int          // What the cached rows were wrapped for
+	wrapDW   bool
+	pane     *Pane // When laid out in pixels, by a pane
+}
+
+// NewConsole creates a console printing to the region defined by cc
+func (f *FBInk) NewConsole(cc ConsoleConfig) *Console {
+	return &Console{f: f, cc: cc}
+}
+
+// Console returns the session's default console
+func (f *FBInk) Console() *Console {
+	return f.console
+}
+
+// Println prints a new line to the console, scrolling if needed
+func (c *Console) Println(a ...interface{}) (n int, err error) {
+	str := fmt.Sprint(a...)
+	n = len([]byte(str))
+	c.f.do(func() { err = c.println(consoleLine{text: str}) })
+	return n, err
+}
+
+// println appends lines to the console, and draws them at once.
+// It runs on the render goroutine.
+func (c *Console) println(lines ...consoleLine) error {
+	c.layout()
+	for _, line := range lines {
+		line.rows = c.wrap(line.text)
+		if c.offset > 0 {
+			// Keep the view still
+			c.offset += len(line.rows)
+		}
+		c.lines = append(c.lines, line)
+	}
+	return c.draw()
+}
+
+// PrintLastLn replaces the last line of the console, without scrolling
+func (c *Console) PrintLastLn(a ...interface{}) (n int, err error) {
+	str := fmt.Sprint(a...)
+	n = len([]byte(str))
+	c.f.do(func() {
+		c.layout()
+		line := consoleLine{text: str, rows: c.wrap(str)}
+		if len(c.lines) == 0 {
+			c.lines = append(c.lines, line)
+		} else {
+			last := &c.lines[len(c.lines)-1]
+			if c.offset > 0 {
+				c.offset += len(line.rows) - len(last.rows)
+			}
+			*last = line
+		}
+		err = c.draw()
+	})
+	return n, err
+}
+
+// Clear forgets the console's history, and blanks its region
+func (c *Console) Clear() (err error) {
+	c.f.do(func() {
+		c.lines = nil
+		c.offset = 0
+		err = c.draw()
+	})
+	return err
+}
+
+// Following reports whether the view is at the bottom, following new lines
+func (c *Console) Following() (following bool) {
+	c.f.do(func() { following = c.offset == 0 })
+	return following
+}
+
+// PageUp scrolls the view back by a page
+func (c *Console) PageUp() (err error) {
+	c.f.do(func() {
+		_, rows, _, _ := c.region()
+		c.offset += rows
+		err = c.draw()
+	})
+	return err
+}
+
+// PageDown scrolls the view forward by a page, following new lines again
+// once it reaches the bottom
+func (c *Console) PageDown() (err error) {
+	c.f.do(func() {
+		_, rows, _, _ := c.region()
+		c.offset -= rows
+		err = c.draw()
+	})
+	return err
+}
+
+// End jumps back to the bottom, and follows new lines again
+func (c *Console) End() (err error) {
+	c.f.do(func() {
+		c.offset = 0
+		err = c.draw()
+	})
+	return err
+}
+
+// Search looks for the next line containing query, either backwards (in
+// older lines, from the top of the view) or forwards (in newer lines, from
+// the bottom of the view), and scrolls the view to show it at the top (or
+// as close to it as possible). It reports whether a line was found.
+func (c *Console) Search(query string, backwards bool) (found bool, err error) {
+	c.f.do(func() {
+		_, rows, _, _ := c.region()
+		c.layout()
+		// Find the lines at the top & bottom of the view
+		total := c.totalRows()
+		end := total - c.offset
+		start := end - rows
+		topLine, bottomLine := len(c.lines), -1
+		lineRow := make([]int, len(c.lines))
+		row := 0
+		for i, line := range c.lines {
+			lineRow[i] = row
+			if row+len(line.rows) > start && topLine == len(c.lines) {
+				topLine = i
+			}
+			if row < end {
+				bottomLine = i
+			}
+			row += len(line.rows)
+		}
+		match := -1
+		if backwards {
+			for i := topLine - 1; i >= 0; i-- {
+				if strings.Contains(c.lines[i].text, query) {
+					match = i
+					break
+				}
+			}
+		} else {
+			for i := bottomLine + 1; i < len(c.lines); i++ {
+				if strings.Contains(c.lines[i].text, query) {
+					match = i
+					break
+				}
+			}
+		}
+		if match < 0 {
+			return
+		}
+		found = true
+		c.offset = total - lineRow[match] - rows
+		err = c.draw()
+	})
+	return found, err
+}
+
+// region returns the console's region, clamped to the screen.
+// It runs on the render goroutine.
+func (c *Console) region() (row, rows, col, cols int) {
+	state := FBInkState{}
+	c.f.backend.State(&c.f.internCfg, &state)
+	if c.pane != nil {
+		rows, cols = c.pane.grid(&state)
+		return 0, rows, 0, cols
+	}
+	row, col = int(c.cc.Row), int(c.cc.Col)
+	rows, cols = int(c.cc.Rows), int(c.cc.Cols)
+	// Keep clear of the status lines
+	if row < c.f.headers {
+		row = c.f.headers
+	}
+	maxRows := int(state.MaxRows) - c.f.footers
+	if rows <= 0 || row+rows > maxRows {
+		rows = maxRows - row
+	}
+	if cols <= 0 || col+cols > int(state.MaxCols) {
+		cols = int(state.MaxCols) - col
+	}
+	return row, rows, col, cols
+}
+
+// layout makes sure the cached rows match the current layout (eg: after a
+// rotation), before wrapping new lines.
+// It runs on the render goroutine.
+func (c *Console) layout() {
+	_, _, _, cols := c.region()
+	dwFont := c.f.internCfg.fontname == UnifontDW
+	if cols != c.wrapCols || dwFont != c.wrapDW {
+		c.wrapCols, c.wrapDW = cols, dwFont
+		for i := range c.lines {
+			c.lines[i].rows = c.wrap(c.lines[i].text)
+		}
+	}
+}
+
+// wrap breaks a line into rows fitting the console, as laid out
+func (c *Console) wrap(text string) []string {
+	if c.wrapCols < 1 {
+		return nil
+	}
+	var rows []string
+	for _, r := range wrapCells(text, c.wrapCols, c.wrapDW) {
+		rows = append(rows, cellText(r, c.wrapDW))
+	}
+	return rows
+}
+
+func (c *Console) totalRows() int {
+	total := 0
+	for _, line := range c.lines {
+		total += len(line.rows)
+	}
+	return total
+}
+
+// draw redraws the rows that changed, and refreshes them at once.
+// It runs on the render goroutine.
+func (c *Console) draw() error {
+	state := FBInkState{}
+	c.f.backend.State(&c.f.internCfg, &state)
+	row, rows, col, cols := c.region()
+	if rows < 1 || cols < 1 {
+		return createError("fbink_print", eRange)
+	}
+	c.layout()
+	maxLines := c.cc.MaxLines
+	if maxLines <= 0 || maxLines > rows {
+		maxLines = rows
+	}
+	// Trim the history to what the view can show, plus the scrollback,
+	// counting rows like the offset does
+	total := c.totalRows()
+	drop := 0
+	for drop < len(c.lines)-1 && total-len(c.lines[drop].rows) >= rows+c.cc.Scrollback {
+		total -= len(c.lines[drop].rows)
+		drop++
+	}
+	if drop > 0 {
+		c.lines = append([]consoleLine(nil), c.lines[drop:]...)
+	}
+	var window []consoleRow
+	appendRows := func(line consoleLine) {
+		for _, r := range line.rows {
+			window = append(window, consoleRow{r, line.style})
+		}
+	}
+	if c.offset > total-rows {
+		c.offset = total - rows
+	}
+	if c.offset <= 0 {
+		// Following, show the latest lines
+		c.offset = 0
+		first := len(c.lines) - maxLines
+		if first < 0 {
+			first = 0
+		}
+		for _, line := range c.lines[first:] {
+			appendRows(line)
+		}
+		if len(window) > rows {
+			window = window[len(window)-rows:]
+		}
+	} else {
+		end := total - c.offset
+		for _, line := range c.lines {
+			appendRows(line)
+			if len(window) >= end {
+				break
+			}
+		}
+		window = window[end-rows : end]
+	}
+	redrawAll := len(c.shown) != rows
+	if redrawAll {
+		c.shown = make([]consoleRow, rows)
+		for i := range c.shown {
+			// Force a redraw of the whole region
+			c.shown[i].text = "\x00"
+		}
+	}
+
+	// Sort out the rows to draw, bold ones go last, in one go
+	bold, hasBold := boldFonts[c.f.internCfg.fontname]
+	var regular, bolds []int
+	for i := 0; i < rows; i++ {
+		if i >= len(window) {
+			window = append(window, consoleRow{})
+		}
+		if window[i] == c.shown[i] {
+			continue
+		}
+		if window[i].style.bold && hasBold {
+			bolds = append(bolds, i)
+		} else {
+			regular = append(regular, i)
+		}
+	}
+	if len(regular) == 0 && len(bolds) == 0 {
+		return nil
+	}
+
+	pens := consolePens{f: c.f}
+	pens.reset(color.Gray{state.PenFGcolor}, color.Gray{state.PenBGcolor})
+	sessionFG, sessionBG := pens.fg, pens.bg
+	baseFG, baseBG := sessionFG, sessionBG
+	if c.cc.FGcolor != FGblack || c.cc.BGcolor != BGwhite {
+		baseFG, baseBG = c.cc.FGcolor.Gray(), c.cc.BGcolor.Gray()
+	}
+	defer func() { pens.set(sessionFG, sessionBG) }()
+
+	cfg := c.f.internCfg
+	needsInit := false
+	if c.pane != nil {
+		cfg, needsInit = c.pane.drawCfg(cfg)
+	}
+	// Only libfbink knows about fonts & the font multiplier, and switching
+	// them takes reinitializing, which resets the pens as well. So it's
+	// done at most once per font, and undone once we're done.
+	reinited := false
+	reinit := func(initCfg FBInkConfig) error {
+		if err := c.f.backend.Init(&initCfg); err != nil {
+			return err
+		}
+		reinited = true
+		pens.reset(initCfg.fgColor.Gray(), initCfg.bgColor.Gray())
+		return nil
+	}
+	defer func() {
+		if reinited {
+			c.f.backend.Init(&c.f.internCfg)
+			pens.reset(c.f.internCfg.fgColor.Gray(), c.f.internCfg.bgColor.Gray())
+		}
+	}()
+	cfg.NoRefresh = true
+	cfg.Col = int16(col)
+	if c.pane != nil && redrawAll {
+		pens.set(baseFG, baseBG)
+		if err := c.pane.frame(c.f.backend, &cfg, baseFG.Y); err != nil {
+			return err
+		}
+		defer func() {
+			// Refresh the border as well
+			r := c.pane.outer()
+			c.f.backend.Refresh(uint32(r.Min.Y), uint32(r.Min.X), uint32(r.Dx()), uint32(r.Dy()), &c.f.internCfg)
+		}()
+	}
+	// The union of the rows drawn so far, to refresh them at once, even
+	// when failing halfway
+	first, last := rows, -1
+	drawRow := func(i int) error {
+		first, last = minInt(first, i), maxInt(last, i)
+		r := window[i]
+		fg, bg := baseFG, baseBG
+		highlight := r.style.bold && !hasBold
+		if r.style.dim {
+			fg = FGgray8.Gray()
+		} else if highlight {
+			// Without a bold variant, stand out on a gray background
+			fg, bg = FGblack.Gray(), BGgrayC.Gray()
+		}
+		pens.set(fg, bg)
+		if c.pane != nil {
+			c.pane.place(&cfg, i)
+		} else {
+			cfg.Row = int16(row + i)
+		}
+		inverted := r.style.inverted
+		if highlight && pens.noPens {
+			// Or, without pens, by (un)inverting the row
+			inverted = !inverted
+		}
+		cfg.IsInverted = c.f.internCfg.IsInverted != inverted
+		// Pad the row, to overwrite whatever was there before.
+		// cellText guarantees one codepoint per cell.
+		pad := cols - utf8.RuneCountInString(r.text)
+		if pad < 0 {
+			// Only a wide character on a single column console can overflow
+			pad = 0
+		}
+		if _, err := c.f.backend.Print(r.text+strings.Repeat(" ", pad), &cfg); err != nil {
+			c.shown[i].text = "\x00"
+			return err
+		}
+		c.shown[i] = r
+		return nil
+	}
+	err := c.drawRows(cfg, needsInit, regular, bolds, bold, drawRow, reinit)
+	if last < 0 || (c.pane != nil && redrawAll) {
+		return err
+	}
+	if c.pane != nil {
+		r := c.pane.rowsRect(first, last)
+		if refreshErr := c.f.backend.Refresh(uint32(r.Min.Y), uint32(r.Min.X), uint32(r.Dx()), uint32(r.Dy()), &c.f.internCfg); err == nil {
+			err = refreshErr
+		}
+		return err
+	}
+	cfg.Row = int16(row + first)
+	cfg.IsInverted = c.f.internCfg.IsInverted
+	if refreshErr := c.f.backend.GridRefresh(uint16(cols), uint16(last-first+1), &cfg); err == nil {
+		err = refreshErr
+	}
+	return err
+}
+
+// drawRows draws the regular rows, then the bold ones in the bold font,
+// reinitializing for the regular ones only if needsInit
+func (c *Console) drawRows(cfg FBInkConfig, needsInit bool, regular, bolds []int, bold Font, drawRow func(int) error, reinit func(FBInkConfig) error) error {
+	if len(regular) > 0 && needsInit {
+		if err := reinit(cfg); err != nil {
+			return err
+		}
+	}
+	for _, i := range regular {
+		if err := drawRow(i); err != nil {
+			return err
+		}
+	}
+	if len(bolds) == 0 {
+		return nil
+	}
+	boldCfg := cfg
+	boldCfg.fontname = bold
+	if err := reinit(boldCfg); err != nil {
+		return err
+	}
+	for _, i := range bolds {
+		if err := drawRow(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// consolePens keeps track of the pen colors while drawing, to only set
+// them when needed. Without libfbink, there are no pens, so it sticks to
+// the defaults.
+type consolePens struct {
+	f      *FBInk
+	fg, bg color.Gray
+	noPens bool
+}
+
+// reset records the pen colors as they currently are
+func (p *consolePens) reset(fg, bg color.Gray) {
+	p.fg, p.bg = fg, bg
+}
+
+func (p *consolePens) set(fg, bg color.Gray) {
+	if p.noPens || (fg == p.fg && bg == p.bg) {
+		return
+	}
+	if err := p.f.setPens(fg, bg); err != nil {
+		p.noPens = true
+		return
+	}
+	p.fg, p.bg = fg, bg
+}
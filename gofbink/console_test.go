@@ -0,0 +1,243 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"fmt"
+	"image"
+	"reflect"
+	"testing"
+)
+
+// newTestConsole returns a 10x4 console at the top of a virtual
+// framebuffer, with the given scrollback
+func newTestConsole(t *testing.T, scrollback int) *Console {
+	t.Helper()
+	f, _, _ := newTestSession(t)
+	return f.NewConsole(ConsoleConfig{Rows: 4, Scrollback: scrollback})
+}
+
+// consoleView returns the rows the console shows
+func consoleView(c *Console) (view []string) {
+	c.f.do(func() {
+		for _, r := range c.shown {
+			view = append(view, r.text)
+		}
+	})
+	return view
+}
+
+func numbers(from, to int) []string {
+	var s []string
+	for i := from; i <= to; i++ {
+		s = append(s, fmt.Sprint(i))
+	}
+	return s
+}
+
+func TestConsolePaging(t *testing.T) {
+	type step struct {
+		name      string
+		do        func(c *Console) error
+		view      []string
+		following bool
+	}
+	println := func(lines ...string) func(c *Console) error {
+		return func(c *Console) error {
+			for _, l := range lines {
+				if _, err := c.Println(l); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	tests := []struct {
+		name       string
+		scrollback int
+		steps      []step
+	}{
+		{
+			name:       "follow",
+			scrollback: 6,
+			steps: []step{
+				{"first lines", println("1", "2"), []string{"1", "2", "", ""}, true},
+				{"scrolls", println("3", "4", "5", "6"), []string{"3", "4", "5", "6"}, true},
+			},
+		},
+		{
+			name:       "paging clamps",
+			scrollback: 6,
+			steps: []step{
+				{"fill", println(numbers(1, 6)...), []string{"3", "4", "5", "6"}, true},
+				{"page up to the top", (*Console).PageUp, []string{"1", "2", "3", "4"}, false},
+				{"new lines don't move the view", println("7"), []string{"1", "2", "3", "4"}, false},
+				{"page up at the top", (*Console).PageUp, []string{"1", "2", "3", "4"}, false},
+				{"page down to the bottom", (*Console).PageDown, []string{"4", "5", "6", "7"}, true},
+				{"page down at the bottom", (*Console).PageDown, []string{"4", "5", "6", "7"}, true},
+				{"page up", (*Console).PageUp, []string{"1", "2", "3", "4"}, false},
+				{"end", (*Console).End, []string{"4", "5", "6", "7"}, true},
+			},
+		},
+		{
+			name:       "scrollback is trimmed in rows",
+			scrollback: 6,
+			steps: []step{
+				{"fill", println(numbers(1, 20)...), []string{"17", "18", "19", "20"}, true},
+				{"page up", (*Console).PageUp, []string{"13", "14", "15", "16"}, false},
+				{"page up to the top", (*Console).PageUp, []string{"11", "12", "13", "14"}, false},
+				{"trimming keeps the view in range", println("21"), []string{"12", "13", "14", "15"}, false},
+			},
+		},
+		{
+			name:       "wrapped lines count as several rows",
+			scrollback: 2,
+			steps: []step{
+				{"fill", println("a", "0123456789012345678901234", "b", "c", "d"), []string{"01234", "b", "c", "d"}, true},
+				{"page up to the top", (*Console).PageUp, []string{"0123456789", "0123456789", "01234", "b"}, false},
+			},
+		},
+		{
+			name:       "no scrollback",
+			scrollback: 0,
+			steps: []step{
+				{"fill", println(numbers(1, 6)...), []string{"3", "4", "5", "6"}, true},
+				{"page up does nothing", (*Console).PageUp, []string{"3", "4", "5", "6"}, true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConsole(t, tt.scrollback)
+			for _, s := range tt.steps {
+				if err := s.do(c); err != nil {
+					t.Fatalf("%s: %v", s.name, err)
+				}
+				if got := consoleView(c); !reflect.DeepEqual(got, s.view) {
+					t.Errorf("%s: view = %q, want %q", s.name, got, s.view)
+				}
+				if got := c.Following(); got != s.following {
+					t.Errorf("%s: following = %v, want %v", s.name, got, s.following)
+				}
+			}
+		})
+	}
+}
+
+func TestConsoleSearch(t *testing.T) {
+	c := newTestConsole(t, 6)
+	for _, l := range []string{"apple", "banana", "cherry", "date", "elder", "fig", "grape"} {
+		if _, err := c.Println(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	steps := []struct {
+		query     string
+		backwards bool
+		found     bool
+		view      []string
+	}{
+		{"grape", true, false, []string{"date", "elder", "fig", "grape"}}, // In view
+		{"apple", false, false, []string{"date", "elder", "fig", "grape"}},
+		{"an", true, true, []string{"banana", "cherry", "date", "elder"}},
+		{"a", true, true, []string{"apple", "banana", "cherry", "date"}},
+		{"a", true, false, []string{"apple", "banana", "cherry", "date"}},
+		{"nope", false, false, []string{"apple", "banana", "cherry", "date"}},
+		{"e", false, true, []string{"date", "elder", "fig", "grape"}}, // As close to the top as possible
+		{"fig", false, false, []string{"date", "elder", "fig", "grape"}},
+	}
+	for _, s := range steps {
+		found, err := c.Search(s.query, s.backwards)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != s.found {
+			t.Errorf("Search(%q, %v) = %v, want %v", s.query, s.backwards, found, s.found)
+		}
+		if got := consoleView(c); !reflect.DeepEqual(got, s.view) {
+			t.Errorf("Search(%q, %v): view = %q, want %q", s.query, s.backwards, got, s.view)
+		}
+	}
+	if !c.Following() {
+		t.Error("a match in the last page should follow again")
+	}
+}
+
+func TestConsoleBoldFallback(t *testing.T) {
+	// The virtual framebuffer's font has no bold variant, nor pens, so bold
+	// rows are inverted instead
+	f, v, _ := newTestSession(t)
+	c := f.NewConsole(ConsoleConfig{Rows: 4})
+	var err error
+	f.do(func() {
+		err = c.println(consoleLine{text: "warn", style: lineStyle{bold: true}}, consoleLine{text: "ok"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPixels(t, v.Image(), map[image.Point]uint8{
+		{4, 4}:    0xFF, // "w", inverted
+		{100, 4}:  0x00, // Padding, inverted
+		{4, 20}:   0x00, // "o"
+		{100, 20}: 0xFF,
+	})
+}
+
+func TestConsoleBoldInits(t *testing.T) {
+	bold, regular := consoleLine{text: "warn", style: lineStyle{bold: true}}, consoleLine{text: "ok"}
+	tests := []struct {
+		name  string
+		pane  bool
+		lines []consoleLine
+		inits int
+	}{
+		{"regular", false, []consoleLine{regular, regular}, 0},
+		{"bold", false, []consoleLine{bold, regular}, 2},
+		{"pane", true, []consoleLine{regular, regular}, 2},
+		{"bold pane", true, []consoleLine{bold, regular}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := &initCounter{Backend: NewVirtualFB(160, 240, 150, 0)}
+			cfg := FBInkConfig{}
+			f := NewWithBackend(counter, &cfg, &RestrictedConfig{Fontname: Terminus})
+			defer f.Close()
+			c := f.NewConsole(ConsoleConfig{Rows: 4})
+			if tt.pane {
+				// With a multiplier of its own
+				c = f.NewPane(PaneConfig{Rect: image.Rect(0, 0, 160, 64), Fontmult: 1}).Console
+			}
+			counter.inits = 0
+			var err error
+			f.do(func() { err = c.println(tt.lines...) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Once per font, and once to restore the session's
+			if counter.inits != tt.inits {
+				t.Errorf("%d inits, want %d", counter.inits, tt.inits)
+			}
+		})
+	}
+}
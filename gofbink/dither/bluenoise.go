@@ -0,0 +1,134 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package dither
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// blueNoiseSize is the size of the (tiled) blue noise threshold map
+const blueNoiseSize = 64
+
+var (
+	blueNoiseOnce  sync.Once
+	blueNoiseRanks []uint16
+)
+
+// blueNoise returns the blue noise threshold map, generated on first use
+// with the void-and-cluster method (Ulichney, 1993)
+func blueNoise() []uint16 {
+	blueNoiseOnce.Do(func() { blueNoiseRanks = voidAndCluster(blueNoiseSize, 1.5) })
+	return blueNoiseRanks
+}
+
+// voidAndCluster returns the ranks of a size x size blue noise map
+func voidAndCluster(size int, sigma float64) []uint16 {
+	n := size * size
+	// Gaussian energy of a point on the others, on a torus
+	kern := make([]float64, n)
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			x, y := math.Min(float64(dx), float64(size-dx)), math.Min(float64(dy), float64(size-dy))
+			kern[dy*size+dx] = math.Exp(-(x*x + y*y) / (2 * sigma * sigma))
+		}
+	}
+	ones := make([]bool, n)
+	energy := make([]float64, n)
+	toggle := func(p int, on bool) {
+		ones[p] = on
+		d := 1.0
+		if !on {
+			d = -1
+		}
+		px, py := p%size, p/size
+		for y := 0; y < size; y++ {
+			row := energy[y*size : (y+1)*size]
+			k := kern[((y-py+size)%size)*size:]
+			// The kernel row, rotated by px
+			for x, e := range k[:size-px] {
+				row[px+x] += d * e
+			}
+			for x, e := range k[size-px : size] {
+				row[x] += d * e
+			}
+		}
+	}
+	// tightestCluster is the set point with the highest energy, and
+	// largestVoid the unset one with the lowest
+	tightestCluster := func() int {
+		best := -1
+		for p, on := range ones {
+			if on && (best < 0 || energy[p] > energy[best]) {
+				best = p
+			}
+		}
+		return best
+	}
+	largestVoid := func() int {
+		best := -1
+		for p, on := range ones {
+			if !on && (best < 0 || energy[p] < energy[best]) {
+				best = p
+			}
+		}
+		return best
+	}
+
+	// Initial pattern: a tenth of the points at random, spread out evenly
+	rnd := rand.New(rand.NewSource(1))
+	initial := n / 10
+	for _, p := range rnd.Perm(n)[:initial] {
+		toggle(p, true)
+	}
+	for {
+		c := tightestCluster()
+		toggle(c, false)
+		v := largestVoid()
+		toggle(v, true)
+		if v == c {
+			break
+		}
+	}
+	prototype := append([]bool(nil), ones...)
+	protoEnergy := append([]float64(nil), energy...)
+
+	ranks := make([]uint16, n)
+	// Rank the initial points, tightest clusters last
+	for r := initial - 1; r >= 0; r-- {
+		c := tightestCluster()
+		toggle(c, false)
+		ranks[c] = uint16(r)
+	}
+	// Then fill the voids, largest first
+	copy(ones, prototype)
+	copy(energy, protoEnergy)
+	for r := initial; r < n; r++ {
+		v := largestVoid()
+		toggle(v, true)
+		ranks[v] = uint16(r)
+	}
+	return ranks
+}
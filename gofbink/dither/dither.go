@@ -0,0 +1,216 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package dither quantizes images to the gray levels of eInk panels, in
+// pure Go, so that dithered images look the same on every device, whatever
+// its EPDC supports.
+package dither
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Algorithm type
+type Algorithm int
+
+// Algorithm constants
+const (
+	FloydSteinberg Algorithm = iota
+	Atkinson
+	Stucki
+	BlueNoise
+	Bayer // 8x8 ordered dithering
+)
+
+// Ditherer quantizes images to a number of evenly spaced gray levels:
+// 16 for regular waveform modes, 4 for DU4, or 2 for A2 & DU
+type Ditherer struct {
+	Algorithm Algorithm
+	Levels    int // 0 means 16
+	// Serpentine alternates the scanning direction of error diffusion
+	// from one row to the next, which avoids some directional artifacts
+	Serpentine bool
+}
+
+// Common setups
+var (
+	Gray16 = &Ditherer{Algorithm: FloydSteinberg, Levels: 16, Serpentine: true}
+	Gray4  = &Ditherer{Algorithm: FloydSteinberg, Levels: 4, Serpentine: true}
+	Mono   = &Ditherer{Algorithm: Atkinson, Levels: 2}
+)
+
+// Dither returns a quantized copy of im, with the same bounds.
+// Transparent pixels are composited over white.
+func (d *Ditherer) Dither(im image.Image) *image.Gray {
+	b := im.Bounds()
+	gray := image.NewGray(b)
+	draw.Draw(gray, b, image.White, image.Point{}, draw.Src)
+	draw.Draw(gray, b, im, b.Min, draw.Over)
+	q := d.quantizer()
+	switch d.Algorithm {
+	case Atkinson:
+		d.diffuse(gray, q, atkinson)
+	case Stucki:
+		d.diffuse(gray, q, stucki)
+	case BlueNoise:
+		ordered(gray, q, blueNoise(), blueNoiseSize)
+	case Bayer:
+		ordered(gray, q, bayer8[:], 8)
+	default:
+		d.diffuse(gray, q, floydSteinberg)
+	}
+	return gray
+}
+
+// Model returns the color.Model of the ditherer's gray levels (without the
+// dithering)
+func (d *Ditherer) Model() color.Model {
+	q := d.quantizer()
+	return color.ModelFunc(func(c color.Color) color.Color {
+		g := color.GrayModel.Convert(c).(color.Gray)
+		return color.Gray{q.quantize(float32(g.Y))}
+	})
+}
+
+type quantizer struct {
+	step float32
+}
+
+func (d *Ditherer) quantizer() quantizer {
+	switch {
+	case d.Levels <= 0:
+		return quantizer{step: 255 / 15.0}
+	case d.Levels == 1:
+		return quantizer{step: 255}
+	case d.Levels > 256:
+		return quantizer{step: 1}
+	}
+	return quantizer{step: 255 / float32(d.Levels-1)}
+}
+
+// quantize returns the level closest to v
+func (q quantizer) quantize(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	n := float32(int(v/q.step + 0.5))
+	return uint8(n*q.step + 0.5)
+}
+
+// kernel is an error diffusion kernel
+type kernel struct {
+	div     float32
+	weights []struct{ dx, dy, w int }
+}
+
+var floydSteinberg = kernel{16, []struct{ dx, dy, w int }{
+	{1, 0, 7},
+	{-1, 1, 3}, {0, 1, 5}, {1, 1, 1},
+}}
+
+// Atkinson only diffuses 3/4 of the error, which preserves contrast
+var atkinson = kernel{8, []struct{ dx, dy, w int }{
+	{1, 0, 1}, {2, 0, 1},
+	{-1, 1, 1}, {0, 1, 1}, {1, 1, 1},
+	{0, 2, 1},
+}}
+
+var stucki = kernel{42, []struct{ dx, dy, w int }{
+	{1, 0, 8}, {2, 0, 4},
+	{-2, 1, 2}, {-1, 1, 4}, {0, 1, 8}, {1, 1, 4}, {2, 1, 2},
+	{-2, 2, 1}, {-1, 2, 2}, {0, 2, 4}, {1, 2, 2}, {2, 2, 1},
+}}
+
+// diffuse quantizes img in place, spreading the error with k
+func (d *Ditherer) diffuse(img *image.Gray, q quantizer, k kernel) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// Errors of the current row, and the next two
+	errs := [3][]float32{make([]float32, w), make([]float32, w), make([]float32, w)}
+	for y := 0; y < h; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+		x0, x1, dir := 0, w, 1
+		if d.Serpentine && y%2 == 1 {
+			x0, x1, dir = w-1, -1, -1
+		}
+		for x := x0; x != x1; x += dir {
+			v := float32(row[x]) + errs[0][x]
+			row[x] = q.quantize(v)
+			e := (v - float32(row[x])) / k.div
+			for _, kw := range k.weights {
+				ex := x + kw.dx*dir
+				if ex < 0 || ex >= w || y+kw.dy >= h {
+					continue
+				}
+				errs[kw.dy][ex] += e * float32(kw.w)
+			}
+		}
+		errs[0], errs[1], errs[2] = errs[1], errs[2], errs[0]
+		for i := range errs[2] {
+			errs[2][i] = 0
+		}
+	}
+}
+
+// bayer8 is the 8x8 Bayer threshold matrix
+var bayer8 = [64]uint16{
+	0, 32, 8, 40, 2, 34, 10, 42,
+	48, 16, 56, 24, 50, 18, 58, 26,
+	12, 44, 4, 36, 14, 46, 6, 38,
+	60, 28, 52, 20, 62, 30, 54, 22,
+	3, 35, 11, 43, 1, 33, 9, 41,
+	51, 19, 59, 27, 49, 17, 57, 25,
+	15, 47, 7, 39, 13, 45, 5, 37,
+	63, 31, 55, 23, 61, 29, 53, 21,
+}
+
+// ordered quantizes img in place, against a size x size threshold map of
+// ranks, tiled from the origin (so that the pattern lines up across
+// SubImages, whatever their bounds)
+func ordered(img *image.Gray, q quantizer, ranks []uint16, size int) {
+	b := img.Bounds()
+	n := float32(len(ranks))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			r := ranks[mod(y, size)*size+mod(b.Min.X+x, size)]
+			// Offset the value by up to half a step either way
+			t := (float32(r)+0.5)/n - 0.5
+			row[x] = q.quantize(float32(row[x]) + t*q.step)
+		}
+	}
+}
+
+// mod returns the non-negative remainder of a / n
+func mod(a, n int) int {
+	a %= n
+	if a < 0 {
+		a += n
+	}
+	return a
+}
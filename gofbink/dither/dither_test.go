@@ -0,0 +1,143 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package dither
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func gradient(r image.Rectangle) *image.Gray {
+	img := image.NewGray(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetGray(x, y, color.Gray{uint8((x - r.Min.X) * 255 / (r.Dx() - 1))})
+		}
+	}
+	return img
+}
+
+func flat(r image.Rectangle, v uint8) *image.Gray {
+	img := image.NewGray(r)
+	for i := range img.Pix {
+		img.Pix[i] = v
+	}
+	return img
+}
+
+func average(img *image.Gray) float64 {
+	sum := 0
+	for _, v := range img.Pix {
+		sum += int(v)
+	}
+	return float64(sum) / float64(len(img.Pix))
+}
+
+var algorithms = []Algorithm{FloydSteinberg, Atkinson, Stucki, BlueNoise, Bayer}
+
+func TestLevels(t *testing.T) {
+	src := gradient(image.Rect(0, 0, 64, 16))
+	for _, a := range algorithms {
+		for _, levels := range []int{0, 2, 4, 16} {
+			d := &Ditherer{Algorithm: a, Levels: levels, Serpentine: true}
+			n := levels
+			if n == 0 {
+				n = 16
+			}
+			allowed := map[uint8]bool{}
+			for i := 0; i < n; i++ {
+				allowed[uint8(float32(i)*255/float32(n-1)+0.5)] = true
+			}
+			out := d.Dither(src)
+			if out.Bounds() != src.Bounds() {
+				t.Errorf("%v/%d: bounds = %v, want %v", a, levels, out.Bounds(), src.Bounds())
+			}
+			seen := map[uint8]bool{}
+			for _, v := range out.Pix {
+				if !allowed[v] {
+					t.Errorf("%v/%d: level %d isn't one of the %d levels", a, levels, v, n)
+					break
+				}
+				seen[v] = true
+			}
+			if len(seen) < 2 || len(seen) > n {
+				t.Errorf("%v/%d: %d levels used, want 2 to %d", a, levels, len(seen), n)
+			}
+		}
+	}
+}
+
+func TestSerpentine(t *testing.T) {
+	src := gradient(image.Rect(0, 0, 32, 32))
+	for _, a := range []Algorithm{FloydSteinberg, Atkinson, Stucki} {
+		scan := (&Ditherer{Algorithm: a, Levels: 2}).Dither(src)
+		serpentine := (&Ditherer{Algorithm: a, Levels: 2, Serpentine: true}).Dither(src)
+		same := true
+		for i := range scan.Pix {
+			same = same && scan.Pix[i] == serpentine.Pix[i]
+		}
+		if same {
+			t.Errorf("%v: serpentine and raster scans gave the same output", a)
+		}
+	}
+}
+
+func TestOrderedAverage(t *testing.T) {
+	for _, a := range []Algorithm{Bayer, BlueNoise} {
+		for _, v := range []uint8{0x40, 0x80, 0xC0} {
+			// Negative bounds mustn't throw the threshold map off either
+			for _, r := range []image.Rectangle{image.Rect(0, 0, 64, 64), image.Rect(-37, -64, 27, 0)} {
+				out := (&Ditherer{Algorithm: a, Levels: 2}).Dither(flat(r, v))
+				if avg := average(out); avg < float64(v)-2 || avg > float64(v)+2 {
+					t.Errorf("%v, %v: average of a flat %#x = %.2f", a, r, v, avg)
+				}
+			}
+		}
+	}
+}
+
+func TestOrderedTiles(t *testing.T) {
+	// The pattern is anchored to the origin, whatever the bounds
+	full := (&Ditherer{Algorithm: Bayer, Levels: 2}).Dither(flat(image.Rect(-16, -16, 16, 16), 0x60))
+	sub := (&Ditherer{Algorithm: Bayer, Levels: 2}).Dither(flat(image.Rect(-5, -3, 11, 13), 0x60))
+	b := sub.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if got, want := sub.GrayAt(x, y), full.GrayAt(x, y); got != want {
+				t.Fatalf("(%d, %d) = %d, want %d", x, y, got.Y, want.Y)
+			}
+		}
+	}
+}
+
+func TestDitherTransparent(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	out := Mono.Dither(img)
+	for _, v := range out.Pix {
+		if v != 0xFF {
+			t.Fatalf("transparent pixel dithered to %d, want white", v)
+		}
+	}
+}
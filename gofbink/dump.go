@@ -0,0 +1,127 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"image"
+	"runtime"
+)
+
+// dumpData holds the pixels of a dump, wherever the backend put them
+type dumpData interface {
+	bytes() []byte
+	free() error
+}
+
+// goDumpData is dump data living in Go memory
+type goDumpData []byte
+
+func (g goDumpData) bytes() []byte {
+	return g
+}
+
+func (g goDumpData) free() error {
+	return nil
+}
+
+// NewDump returns a dump of the area of the screen, holding data, for
+// Backend implementations. data are the area's pixels, stride bytes per
+// row, in whatever layout the backend's Restore expects (eg: as they are
+// in the framebuffer, at bpp bits per pixel & rotation rota).
+func NewDump(data []byte, stride int, area image.Rectangle, rota, bpp uint8, isFull bool) *FBInkDump {
+	d := &FBInkDump{
+		Stride: uint(stride),
+		Size:   uint(len(data)),
+		Area:   FBInkRect{Left: uint16(area.Min.X), Top: uint16(area.Min.Y), Width: uint16(area.Dx()), Height: uint16(area.Dy())},
+		Rota:   rota,
+		BPP:    bpp,
+		IsFull: isFull,
+	}
+	d.setData(goDumpData(data))
+	return d
+}
+
+// Data returns the dump's pixels, without copying them, or nil once the
+// dump has been freed. The slice must not outlive the dump, nor be used
+// after Free.
+func (d *FBInkDump) Data() []byte {
+	return d.bytes()
+}
+
+// setData attaches the dump's pixels. They are released by Free, or by
+// the garbage collector if the caller never gets around to it.
+func (d *FBInkDump) setData(data dumpData) {
+	d.data = data
+	runtime.SetFinalizer(d, (*FBInkDump).Free)
+}
+
+// bytes returns the dump's pixels, without copying them.
+// The slice must not outlive the dump, nor be used after Free.
+func (d *FBInkDump) bytes() []byte {
+	if d.data == nil {
+		return nil
+	}
+	return d.data.bytes()
+}
+
+// Free releases the framebuffer data held by the dump. The dump cannot
+// be restored afterwards. Calling Free is optional, as the data is also
+// released when the dump is garbage collected, but a full screen dump
+// is large enough that you probably want to do it yourself.
+// See "fbink.h" for detailed usage and explanation
+func (d *FBInkDump) Free() error {
+	if d.data == nil {
+		return nil
+	}
+	err := d.data.free()
+	d.data = nil
+	runtime.SetFinalizer(d, nil)
+	return err
+}
+
+// Dump takes a snapshot of the whole screen
+// See "fbink.h" for detailed usage and explanation
+func (f *FBInk) Dump() (dump *FBInkDump, err error) {
+	f.do(func() { dump, err = f.backend.Dump() })
+	return dump, err
+}
+
+// RegionDump takes a snapshot of a specific region of the screen
+// Positioning honors any combination of Halign/Valign, Row/Col & x/y
+// See "fbink.h" for detailed usage and explanation
+func (f *FBInk) RegionDump(x, y int16, w, h uint16, cfg *FBInkConfig) (dump *FBInkDump, err error) {
+	f.do(func() { dump, err = f.backend.RegionDump(x, y, w, h, cfg) })
+	return dump, err
+}
+
+// Restore puts a dump made by Dump or RegionDump back on the screen,
+// at the coordinates it was taken from. If dump.Clip is set, only the
+// intersection of Clip (in screen coordinates) and the dump's area is
+// restored. Cropping a full dump also requires clearing dump.IsFull.
+// A dump can only be restored by the backend that made it.
+// See "fbink.h" for detailed usage and explanation
+func (f *FBInk) Restore(dump *FBInkDump, cfg *FBInkConfig) (err error) {
+	f.do(func() { err = f.backend.Restore(dump, cfg) })
+	return err
+}
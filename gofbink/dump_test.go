@@ -0,0 +1,66 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestNewDump(t *testing.T) {
+	data := []byte{0x00, 0x40, 0xFF, 0x80, 0xC0, 0xFF}
+	dump := NewDump(data, 3, image.Rect(10, 20, 12, 22), 0, 8, false)
+	want := FBInkDump{Stride: 3, Size: 6, Area: fbRect(10, 20, 2, 2), BPP: 8}
+	if dump.Stride != want.Stride || dump.Size != want.Size || dump.Area != want.Area ||
+		dump.Rota != want.Rota || dump.BPP != want.BPP || dump.IsFull {
+		t.Errorf("NewDump = %+v, want %+v", *dump, want)
+	}
+	if got := dump.Data(); !bytes.Equal(got, data) {
+		t.Errorf("Data = %v, want %v", got, data)
+	}
+
+	// The pure Go backends restore dumps made with it
+	v, cfg := newTestVFB(t)
+	if err := v.Restore(dump, cfg); err != nil {
+		t.Fatal(err)
+	}
+	checkPixels(t, v.Image(), map[image.Point]uint8{
+		{10, 20}: 0x00,
+		{11, 20}: 0x40,
+		{10, 21}: 0x80,
+		{11, 21}: 0xC0,
+		{12, 20}: 0xFF, // Past the stride padding
+	})
+
+	if err := dump.Free(); err != nil {
+		t.Fatal(err)
+	}
+	if dump.Data() != nil {
+		t.Error("Data isn't nil after Free")
+	}
+	if err := v.Restore(dump, cfg); err == nil {
+		t.Error("a freed dump was restored")
+	}
+}
@@ -0,0 +1,120 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors matching FBInk's (negated) return codes.
+// Use errors.Is to check for them, as they are always wrapped in an *Error.
+var (
+	ErrFailure         = errors.New("EXIT_FAILURE")
+	ErrNoDevice        = errors.New("ENODEV")
+	ErrNotSupported    = errors.New("ENOTSUP")
+	ErrNoData          = errors.New("ENODATA")
+	ErrTimeout         = errors.New("ETIME")
+	ErrInvalid         = errors.New("EINVAL")
+	ErrIllegalSequence = errors.New("EILSEQ")
+	ErrRange           = errors.New("ERANGE")
+	ErrNoSpace         = errors.New("ENOSPC")
+	ErrNotImplemented  = errors.New("ENOSYS")
+)
+
+var errByCode = map[CexitCode]error{
+	exitFailure: ErrFailure,
+	eNoDev:      ErrNoDevice,
+	eNotSup:     ErrNotSupported,
+	eNoData:     ErrNoData,
+	eTime:       ErrTimeout,
+	eInval:      ErrInvalid,
+	eIlSeq:      ErrIllegalSequence,
+	eRange:      ErrRange,
+	eNoSpc:      ErrNoSpace,
+	eNoSys:      ErrNotImplemented,
+}
+
+// Error records a failed FBInk call
+type Error struct {
+	Op   string    // The FBInk function that failed (eg: "fbink_print")
+	Code CexitCode // The (negative) value it returned
+	Err  error     // One of the Err* sentinels
+}
+
+func (e *Error) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying sentinel error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// createError turns the return value of an FBInk function into an error.
+// Positive values aren't errors, and return nil.
+func createError(op string, retValue CexitCode) error {
+	if retValue >= exitSuccess {
+		return nil
+	}
+	err, ok := errByCode[retValue]
+	if !ok {
+		err = fmt.Errorf("unknown error code %d", retValue)
+	}
+	return &Error{Op: op, Code: retValue, Err: err}
+}
+
+// ReinitChange is the set of framebuffer changes detected by ReInit
+type ReinitChange CexitCode
+
+// ReinitChange flags
+const (
+	BPPChanged    = ReinitChange(exitOkBitdepthChange)
+	RotaChanged   = ReinitChange(exitOkRotaChange)
+	LayoutChanged = ReinitChange(exitOkLayoutChange) // Implies RotaChanged
+)
+
+// Changed reports whether ReInit actually had to reinitialize anything
+func (c ReinitChange) Changed() bool {
+	return c != 0
+}
+
+func (c ReinitChange) String() string {
+	if c == 0 {
+		return "none"
+	}
+	s := ""
+	for _, flag := range []struct {
+		change ReinitChange
+		name   string
+	}{{BPPChanged, "bpp"}, {RotaChanged, "rota"}, {LayoutChanged, "layout"}} {
+		if c&flag.change != 0 {
+			if s != "" {
+				s += "|"
+			}
+			s += flag.name
+		}
+	}
+	return s
+}
@@ -0,0 +1,819 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"image"
+	"image/draw"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// linux/fb.h
+const (
+	fbioGetVScreenInfo = 0x4600
+	fbioGetFScreenInfo = 0x4602
+)
+
+type fbBitfield struct {
+	Offset   uint32
+	Length   uint32
+	MsbRight uint32
+}
+
+type fbVarScreenInfo struct {
+	Xres         uint32
+	Yres         uint32
+	XresVirtual  uint32
+	YresVirtual  uint32
+	Xoffset      uint32
+	Yoffset      uint32
+	BitsPerPixel uint32
+	Grayscale    uint32
+	Red          fbBitfield
+	Green        fbBitfield
+	Blue         fbBitfield
+	Transp       fbBitfield
+	Nonstd       uint32
+	Activate     uint32
+	Height       uint32 // In mm
+	Width        uint32 // In mm
+	AccelFlags   uint32
+	Pixclock     uint32
+	LeftMargin   uint32
+	RightMargin  uint32
+	UpperMargin  uint32
+	LowerMargin  uint32
+	HsyncLen     uint32
+	VsyncLen     uint32
+	Sync         uint32
+	Vmode        uint32
+	Rotate       uint32
+	Colorspace   uint32
+	Reserved     [4]uint32
+}
+
+type fbFixScreenInfo struct {
+	ID           [16]byte
+	SmemStart    uintptr
+	SmemLen      uint32
+	Type         uint32
+	TypeAux      uint32
+	Visual       uint32
+	Xpanstep     uint16
+	Ypanstep     uint16
+	Ywrapstep    uint16
+	LineLength   uint32
+	MmioStart    uintptr
+	MmioLen      uint32
+	Accel        uint32
+	Capabilities uint16
+	Reserved     [2]uint16
+}
+
+// mxcfb.h, as found on Kobo devices & in mainline i.MX EPDC kernels
+type mxcfbRect struct {
+	Top    uint32
+	Left   uint32
+	Width  uint32
+	Height uint32
+}
+
+type mxcfbAltBufferData struct {
+	PhysAddr        uint32
+	Width           uint32
+	Height          uint32
+	AltUpdateRegion mxcfbRect
+}
+
+// mxcfbUpdateData is the Mark 7 (and mainline) flavor of mxcfb_update_data
+type mxcfbUpdateData struct {
+	UpdateRegion  mxcfbRect
+	WaveformMode  uint32
+	UpdateMode    uint32
+	UpdateMarker  uint32
+	Temp          int32
+	Flags         uint32
+	DitherMode    int32
+	QuantBit      int32
+	AltBufferData mxcfbAltBufferData
+}
+
+// mxcfbUpdateDataNTX is the flavor used by older Kobo kernels
+type mxcfbUpdateDataNTX struct {
+	UpdateRegion  mxcfbRect
+	WaveformMode  uint32
+	UpdateMode    uint32
+	UpdateMarker  uint32
+	Temp          int32
+	Flags         uint32
+	VirtAddr      uintptr
+	AltBufferData mxcfbAltBufferData
+}
+
+type mxcfbUpdateMarkerData struct {
+	UpdateMarker  uint32
+	CollisionTest uint32
+}
+
+func iow(nr, size uintptr) uintptr {
+	return 1<<30 | size<<16 | 'F'<<8 | nr
+}
+
+func iowr(nr, size uintptr) uintptr {
+	return 3<<30 | size<<16 | 'F'<<8 | nr
+}
+
+var (
+	mxcfbSendUpdate               = iow(0x2E, unsafe.Sizeof(mxcfbUpdateData{}))
+	mxcfbSendUpdateNTX            = iow(0x2E, unsafe.Sizeof(mxcfbUpdateDataNTX{}))
+	mxcfbWaitForUpdateComplete    = iowr(0x2F, unsafe.Sizeof(mxcfbUpdateMarkerData{}))
+	mxcfbWaitForUpdateCompleteNTX = iow(0x2F, unsafe.Sizeof(uint32(0)))
+	mxcfbWaveformModes            = map[WaveFormMode]uint32{
+		WfmINIT:   0,
+		WfmDU:     1,
+		WfmGC16:   2,
+		WfmGC4:    3,
+		WfmA2:     4,
+		WfmGL16:   5,
+		WfmREAGL:  6,
+		WfmREAGLD: 7,
+		WfmAUTO:   257,
+	}
+)
+
+const (
+	mxcfbUpdateModePartial   = 0x0
+	mxcfbUpdateModeFull      = 0x1
+	mxcfbTempUseAmbient      = 0x1000
+	mxcfbFlagEnableInversion = 0x01
+)
+
+// EPDC flavors
+const (
+	epdcUnknown = iota
+	epdcMark7
+	epdcNTX
+	epdcNone // Not an eInk framebuffer (or a fake one), nothing to refresh
+)
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// FBDev is a pure Go Backend driving a Linux framebuffer device directly,
+// without libfbink (and thus, without cgo).
+// It handles 8, 16 and 32bpp framebuffers, and refreshes eInk panels via
+// the Kobo (and mainline i.MX) mxcfb ioctls. Kindle kernels, and the
+// fancier bits of FBInk's device support (rotation quirks, fonts...) are
+// out of its scope: use the libfbink backend for those. In particular,
+// Print and PrintOT aren't implemented.
+type FBDev struct {
+	mu       sync.Mutex
+	path     string
+	fake     *fbVarScreenInfo // Geometry of a file backed fake device
+	file     *os.File
+	vinfo    fbVarScreenInfo
+	finfo    fbFixScreenInfo
+	mem      []byte
+	bypp     int
+	screen   image.Rectangle
+	dpi      uint16
+	fg       uint8
+	bg       uint8
+	fontMult int
+	grid     cellGrid
+	epdc     int
+	marker   uint32
+	lastRect FBInkRect
+}
+
+// NewFBDev creates a backend for the framebuffer device at path
+// (eg: "/dev/fb0", which is used if path is empty)
+func NewFBDev(path string) *FBDev {
+	if path == "" {
+		path = "/dev/fb0"
+	}
+	return &FBDev{path: path}
+}
+
+// NewFBDevFile creates a backend drawing to a regular file instead of a
+// device, laid out like a framebuffer of the given size and bitdepth
+// (8: grayscale, 16: RGB565, 32: BGRA). The file is created if needed.
+// Refreshes are no-ops.
+func NewFBDevFile(path string, width, height int, bpp uint32) *FBDev {
+	vinfo := &fbVarScreenInfo{
+		Xres:         uint32(width),
+		Yres:         uint32(height),
+		XresVirtual:  uint32(width),
+		YresVirtual:  uint32(height),
+		BitsPerPixel: bpp,
+	}
+	switch bpp {
+	case 8:
+		vinfo.Grayscale = 1
+	case 16:
+		vinfo.Red = fbBitfield{Offset: 11, Length: 5}
+		vinfo.Green = fbBitfield{Offset: 5, Length: 6}
+		vinfo.Blue = fbBitfield{Offset: 0, Length: 5}
+	default:
+		vinfo.Red = fbBitfield{Offset: 16, Length: 8}
+		vinfo.Green = fbBitfield{Offset: 8, Length: 8}
+		vinfo.Blue = fbBitfield{Offset: 0, Length: 8}
+		vinfo.Transp = fbBitfield{Offset: 24, Length: 8}
+	}
+	return &FBDev{path: path, fake: vinfo, epdc: epdcNone}
+}
+
+// Open opens the framebuffer device
+func (f *FBDev) Open() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.open()
+}
+
+func (f *FBDev) open() error {
+	if f.file != nil {
+		return nil
+	}
+	flags := os.O_RDWR
+	if f.fake != nil {
+		flags |= os.O_CREATE
+	}
+	file, err := os.OpenFile(f.path, flags, 0644)
+	if err != nil {
+		return createError("fbink_open", exitFailure)
+	}
+	f.file = file
+	return nil
+}
+
+// Close unmaps and closes the framebuffer device
+func (f *FBDev) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unmap()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return createError("fbink_close", exitFailure)
+	}
+	return nil
+}
+
+func (f *FBDev) unmap() {
+	if f.mem != nil {
+		syscall.Munmap(f.mem)
+		f.mem = nil
+	}
+}
+
+// screenInfo queries the current framebuffer setup
+func (f *FBDev) screenInfo() (vinfo fbVarScreenInfo, finfo fbFixScreenInfo, err error) {
+	if f.fake != nil {
+		vinfo = *f.fake
+		finfo.LineLength = vinfo.Xres * vinfo.BitsPerPixel / 8
+		finfo.SmemLen = finfo.LineLength * vinfo.Yres
+		copy(finfo.ID[:], "gofbink-file")
+		return vinfo, finfo, nil
+	}
+	fd := f.file.Fd()
+	if err = ioctl(fd, fbioGetVScreenInfo, unsafe.Pointer(&vinfo)); err != nil {
+		return vinfo, finfo, err
+	}
+	err = ioctl(fd, fbioGetFScreenInfo, unsafe.Pointer(&finfo))
+	return vinfo, finfo, err
+}
+
+// Init queries the framebuffer, maps it, and picks up the restricted
+// options of cfg
+func (f *FBDev) Init(cfg *FBInkConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.init(cfg)
+}
+
+func (f *FBDev) init(cfg *FBInkConfig) error {
+	if err := f.open(); err != nil {
+		return err
+	}
+	vinfo, finfo, err := f.screenInfo()
+	if err != nil {
+		return createError("fbink_init", exitFailure)
+	}
+	switch vinfo.BitsPerPixel {
+	case 8, 16, 32:
+	default:
+		return createError("fbink_init", eNotSup)
+	}
+	f.unmap()
+	if f.fake != nil {
+		if err := f.file.Truncate(int64(finfo.SmemLen)); err != nil {
+			return createError("fbink_init", exitFailure)
+		}
+	}
+	mem, err := syscall.Mmap(int(f.file.Fd()), 0, int(finfo.SmemLen), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return createError("fbink_init", exitFailure)
+	}
+	f.vinfo, f.finfo, f.mem = vinfo, finfo, mem
+	f.bypp = int(vinfo.BitsPerPixel / 8)
+	f.screen = image.Rect(0, 0, int(vinfo.Xres), int(vinfo.Yres))
+	// The panel size in mm is often left unset, in which case assume a
+	// (Kobo Touch) 167 DPI screen
+	f.dpi = 167
+	if vinfo.Width != 0 {
+		f.dpi = uint16(vinfo.Xres * 254 / (vinfo.Width * 10))
+	}
+	f.fontMult = int(cfg.fontmult)
+	if f.fontMult == 0 {
+		f.fontMult = ibmFontMult(f.dpi)
+	}
+	f.fg = cfg.fgColor.Gray().Y
+	f.bg = cfg.bgColor.Gray().Y
+	f.grid = newCellGrid(f.screen, f.fontMult, cfg.isCentered)
+	return nil
+}
+
+// ReInit checks whether the framebuffer setup changed (eg: after a
+// rotation), and reinitializes if it did
+func (f *FBDev) ReInit(cfg *FBInkConfig) (ReinitChange, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, f.init(cfg)
+	}
+	vinfo, _, err := f.screenInfo()
+	if err != nil {
+		return 0, createError("fbink_reinit", exitFailure)
+	}
+	var change ReinitChange
+	if vinfo.BitsPerPixel != f.vinfo.BitsPerPixel {
+		change |= BPPChanged
+	}
+	if vinfo.Rotate != f.vinfo.Rotate {
+		change |= RotaChanged
+	}
+	if vinfo.Xres != f.vinfo.Xres || vinfo.Yres != f.vinfo.Yres {
+		change |= LayoutChanged
+	}
+	if change.Changed() {
+		if err := f.init(cfg); err != nil {
+			return change, err
+		}
+	}
+	return change, nil
+}
+
+// State fills state with what we know about the framebuffer
+func (f *FBDev) State(cfg *FBInkConfig, state *FBInkState) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cell := f.grid.cell
+	*state = FBInkState{
+		UserHZ:         100,
+		FontName:       "IBM",
+		ViewWidth:      uint32(f.screen.Dx()),
+		ViewHeight:     uint32(f.screen.Dy()),
+		ScreenWidth:    uint32(f.screen.Dx()),
+		ScreenHeight:   uint32(f.screen.Dy()),
+		BPP:            f.vinfo.BitsPerPixel,
+		DeviceName:     "Linux framebuffer",
+		DeviceCodename: strings.TrimRight(string(f.finfo.ID[:]), "\x00"),
+		DevicePlatform: "fbdev",
+		PenFGcolor:     f.fg,
+		PenBGcolor:     f.bg,
+		ScreenDPI:      f.dpi,
+		FontW:          uint16(cell),
+		FontH:          uint16(cell),
+		MaxCols:        uint16(f.grid.cols),
+		MaxRows:        uint16(f.grid.rows),
+		FontSizeMult:   uint8(f.fontMult),
+		GlyphWidth:     ibmGlyphSize,
+		GlyphHeight:    ibmGlyphSize,
+		IsPerfectFit:   cell != 0 && f.screen.Dx()%cell == 0 && f.screen.Dy()%cell == 0,
+		CurrentRota:    uint8(f.vinfo.Rotate),
+	}
+}
+
+// offset returns the offset of pixel (x, y) in the mapped framebuffer
+func (f *FBDev) offset(x, y int) int {
+	return (y+int(f.vinfo.Yoffset))*int(f.finfo.LineLength) + (x+int(f.vinfo.Xoffset))*f.bypp
+}
+
+func (f *FBDev) load(off int) uint32 {
+	var v uint32
+	for i := 0; i < f.bypp; i++ {
+		v |= uint32(f.mem[off+i]) << (8 * uint(i))
+	}
+	return v
+}
+
+func (f *FBDev) store(off int, v uint32) {
+	for i := 0; i < f.bypp; i++ {
+		f.mem[off+i] = uint8(v >> (8 * uint(i)))
+	}
+}
+
+func packChannel(c uint8, bf fbBitfield) uint32 {
+	return uint32(c) >> (8 - bf.Length) << bf.Offset
+}
+
+func unpackChannel(v uint32, bf fbBitfield) uint8 {
+	c := (v >> bf.Offset) & (1<<bf.Length - 1)
+	// Replicate the high bits in the low ones, so that 0x1F maps to 0xFF
+	c <<= 8 - bf.Length
+	return uint8(c | c>>bf.Length)
+}
+
+// pack converts an RGB color to a pixel value
+func (f *FBDev) pack(r, g, b uint8) uint32 {
+	if f.bypp == 1 {
+		return (19595*uint32(r) + 38470*uint32(g) + 7471*uint32(b) + 1<<15) >> 16
+	}
+	v := packChannel(r, f.vinfo.Red) | packChannel(g, f.vinfo.Green) | packChannel(b, f.vinfo.Blue)
+	if f.vinfo.Transp.Length != 0 {
+		v |= packChannel(0xFF, f.vinfo.Transp)
+	}
+	return v
+}
+
+// unpack converts a pixel value to an RGB color
+func (f *FBDev) unpack(v uint32) (r, g, b uint8) {
+	if f.bypp == 1 {
+		return uint8(v), uint8(v), uint8(v)
+	}
+	return unpackChannel(v, f.vinfo.Red), unpackChannel(v, f.vinfo.Green), unpackChannel(v, f.vinfo.Blue)
+}
+
+func (f *FBDev) fill(r image.Rectangle, c uint8) {
+	r = r.Intersect(f.screen)
+	v := f.pack(c, c, c)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x, off := r.Min.X, f.offset(r.Min.X, y); x < r.Max.X; x, off = x+1, off+f.bypp {
+			f.store(off, v)
+		}
+	}
+}
+
+// readRegion copies part of the framebuffer into an RGBA image
+func (f *FBDev) readRegion(r image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		pix := img.Pix[img.PixOffset(r.Min.X, y):]
+		for x, off := r.Min.X, f.offset(r.Min.X, y); x < r.Max.X; x, off = x+1, off+f.bypp {
+			i := (x - r.Min.X) * 4
+			pix[i], pix[i+1], pix[i+2] = f.unpack(f.load(off))
+			pix[i+3] = 0xFF
+		}
+	}
+	return img
+}
+
+// writeRegion copies an RGBA image to the framebuffer
+func (f *FBDev) writeRegion(img *image.RGBA) {
+	r := img.Rect
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		pix := img.Pix[img.PixOffset(r.Min.X, y):]
+		for x, off := r.Min.X, f.offset(r.Min.X, y); x < r.Max.X; x, off = x+1, off+f.bypp {
+			i := (x - r.Min.X) * 4
+			f.store(off, f.pack(pix[i], pix[i+1], pix[i+2]))
+		}
+	}
+}
+
+// damage updates the last rect, and refreshes it unless NoRefresh is set
+func (f *FBDev) damage(r image.Rectangle, cfg *FBInkConfig) error {
+	r = r.Intersect(f.screen)
+	f.lastRect = FBInkRect{Left: uint16(r.Min.X), Top: uint16(r.Min.Y), Width: uint16(r.Dx()), Height: uint16(r.Dy())}
+	if cfg.NoRefresh || r.Empty() {
+		return nil
+	}
+	return f.refresh(r, cfg)
+}
+
+// refresh sends an update request to the EPDC
+func (f *FBDev) refresh(r image.Rectangle, cfg *FBInkConfig) error {
+	if f.mem == nil {
+		return createError("fbink_refresh", eNoDev)
+	}
+	wfm, ok := mxcfbWaveformModes[cfg.WfmMode]
+	if !ok {
+		return createError("fbink_refresh", eNotSup)
+	}
+	f.marker++
+	if f.marker == LastMarker {
+		f.marker++
+	}
+	if f.epdc == epdcNone {
+		return nil
+	}
+	update := mxcfbUpdateData{
+		UpdateRegion: mxcfbRect{Top: uint32(r.Min.Y), Left: uint32(r.Min.X), Width: uint32(r.Dx()), Height: uint32(r.Dy())},
+		WaveformMode: wfm,
+		UpdateMode:   mxcfbUpdateModePartial,
+		UpdateMarker: f.marker,
+		Temp:         mxcfbTempUseAmbient,
+	}
+	if cfg.IsFlashing {
+		update.UpdateMode = mxcfbUpdateModeFull
+	}
+	if cfg.IsNightmode {
+		update.Flags |= mxcfbFlagEnableInversion
+	}
+	fd := f.file.Fd()
+	if f.epdc == epdcUnknown || f.epdc == epdcMark7 {
+		if cfg.DitheringMode != DitherPassthrough && cfg.DitheringMode != DitherLegacy {
+			update.DitherMode = int32(cfg.DitheringMode)
+			update.QuantBit = 7
+			if cfg.WfmMode == WfmA2 || cfg.WfmMode == WfmDU {
+				update.QuantBit = 1
+			}
+		}
+		err := ioctl(fd, mxcfbSendUpdate, unsafe.Pointer(&update))
+		if err == nil {
+			f.epdc = epdcMark7
+			return nil
+		}
+		if f.epdc == epdcMark7 || (err != syscall.ENOTTY && err != syscall.EINVAL) {
+			return createError("fbink_refresh", exitFailure)
+		}
+	}
+	ntx := mxcfbUpdateDataNTX{
+		UpdateRegion: update.UpdateRegion,
+		WaveformMode: update.WaveformMode,
+		UpdateMode:   update.UpdateMode,
+		UpdateMarker: update.UpdateMarker,
+		Temp:         update.Temp,
+		Flags:        update.Flags,
+	}
+	err := ioctl(fd, mxcfbSendUpdateNTX, unsafe.Pointer(&ntx))
+	if err == nil {
+		f.epdc = epdcNTX
+		return nil
+	}
+	if f.epdc == epdcUnknown && (err == syscall.ENOTTY || err == syscall.EINVAL) {
+		// Not an mxcfb device (eg: the vfb kernel module), so there's
+		// nothing to refresh
+		f.epdc = epdcNone
+		return nil
+	}
+	return createError("fbink_refresh", exitFailure)
+}
+
+// Print isn't implemented, as this backend doesn't have any fonts
+func (f *FBDev) Print(str string, cfg *FBInkConfig) (int, error) {
+	return int(eNoSys), createError("fbink_print", eNoSys)
+}
+
+// PrintOT isn't implemented, as this backend doesn't have any fonts
+func (f *FBDev) PrintOT(str string, otCfg *FBInkOTConfig, cfg *FBInkConfig) (int, FBInkOTFit, error) {
+	return int(eNoSys), FBInkOTFit{}, createError("fbink_print_ot", eNoSys)
+}
+
+// drawImage composites src on screen
+func (f *FBDev) drawImage(op string, src image.Image, x, y int16, cfg *FBInkConfig) error {
+	if f.mem == nil {
+		return createError(op, eNoDev)
+	}
+	b := src.Bounds()
+	p := f.grid.imageOrigin(f.screen, b.Dx(), b.Dy(), x, y, cfg)
+	dst := image.Rectangle{Min: p, Max: p.Add(b.Size())}.Intersect(f.screen)
+	if dst.Empty() {
+		return createError(op, eInval)
+	}
+	if cfg.IsInverted {
+		src = invertImage(src)
+	}
+	buf := f.readRegion(dst)
+	draw.Draw(buf, dst, src, b.Min.Add(dst.Min.Sub(p)), compositeOp(cfg))
+	f.writeRegion(buf)
+	return f.damage(dst, cfg)
+}
+
+// PrintImage decodes an image file with the registered Go decoders,
+// and draws it
+func (f *FBDev) PrintImage(path string, x, y int16, cfg *FBInkConfig) error {
+	img, err := decodeImageFile(path)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.drawImage("fbink_print_image", img, x, y, cfg)
+}
+
+// PrintRawData draws packed Y, YA, RGB or RGBA scanlines
+func (f *FBDev) PrintRawData(data []byte, w, h int, x, y int16, cfg *FBInkConfig) error {
+	img, err := rawImage(data, w, h)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.drawImage("fbink_print_raw_data", img, x, y, cfg)
+}
+
+// ClearScreen paints rect (or the whole screen) in the background color
+func (f *FBDev) ClearScreen(cfg *FBInkConfig, rect *FBInkRect) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.mem == nil {
+		return createError("fbink_cls", eNoDev)
+	}
+	r := f.screen
+	if rect != nil && rect.Width != 0 && rect.Height != 0 {
+		r = image.Rect(int(rect.Left), int(rect.Top), int(rect.Left)+int(rect.Width), int(rect.Top)+int(rect.Height))
+	}
+	bg := f.bg
+	if cfg.IsInverted {
+		bg = f.fg
+	}
+	f.fill(r, bg)
+	return f.damage(r, cfg)
+}
+
+// GridClear paints a block of cells in the background color
+func (f *FBDev) GridClear(cols, rows uint16, cfg *FBInkConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.mem == nil {
+		return createError("fbink_grid_clear", eNoDev)
+	}
+	r := f.grid.rect(int(cols), int(rows), cfg)
+	if !cfg.IsBGless {
+		bg := f.bg
+		if cfg.IsInverted {
+			bg = f.fg
+		}
+		f.fill(r, bg)
+	}
+	return f.damage(r, cfg)
+}
+
+// Refresh refreshes the given region (or the whole screen if it's empty)
+func (f *FBDev) Refresh(top, left, width, height uint32, cfg *FBInkConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := image.Rect(int(left), int(top), int(left+width), int(top+height))
+	if width == 0 && height == 0 && top == 0 && left == 0 {
+		r = f.screen
+	}
+	return f.refresh(r.Intersect(f.screen), cfg)
+}
+
+// GridRefresh refreshes a block of cells
+func (f *FBDev) GridRefresh(cols, rows uint16, cfg *FBInkConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.refresh(f.grid.rect(int(cols), int(rows), cfg).Intersect(f.screen), cfg)
+}
+
+// WaitForSubmission isn't supported by the Kobo kernels
+func (f *FBDev) WaitForSubmission(marker uint32) error {
+	return createError("fbink_wait_for_submission", eNoSys)
+}
+
+// WaitForCompletion blocks until the refresh identified by marker is done
+func (f *FBDev) WaitForCompletion(marker uint32) error {
+	f.mu.Lock()
+	if marker == LastMarker {
+		marker = f.marker
+	}
+	epdc, fd := f.epdc, uintptr(0)
+	if f.file != nil {
+		fd = f.file.Fd()
+	}
+	// Don't hold the lock while the kernel waits for the panel
+	f.mu.Unlock()
+	if marker == LastMarker {
+		return createError("fbink_wait_for_complete", eInval)
+	}
+	var err error
+	switch epdc {
+	case epdcMark7:
+		data := mxcfbUpdateMarkerData{UpdateMarker: marker}
+		err = ioctl(fd, mxcfbWaitForUpdateComplete, unsafe.Pointer(&data))
+	case epdcNTX:
+		err = ioctl(fd, mxcfbWaitForUpdateCompleteNTX, unsafe.Pointer(&marker))
+	}
+	switch err {
+	case nil:
+		return nil
+	case syscall.ETIMEDOUT, syscall.ETIME:
+		return createError("fbink_wait_for_complete", eTime)
+	}
+	return createError("fbink_wait_for_complete", exitFailure)
+}
+
+// LastMarker returns the marker of the last refresh
+func (f *FBDev) LastMarker() uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.marker
+}
+
+// LastRect returns the last drawn area
+func (f *FBDev) LastRect() FBInkRect {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastRect
+}
+
+func (f *FBDev) dump(r image.Rectangle, isFull bool) *FBInkDump {
+	stride := r.Dx() * f.bypp
+	data := make([]byte, stride*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		off := f.offset(r.Min.X, y)
+		copy(data[(y-r.Min.Y)*stride:], f.mem[off:off+stride])
+	}
+	return NewDump(data, stride, r, uint8(f.vinfo.Rotate), uint8(f.vinfo.BitsPerPixel), isFull)
+}
+
+// Dump takes a snapshot of the whole screen
+func (f *FBDev) Dump() (*FBInkDump, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.mem == nil {
+		return nil, createError("fbink_dump", eNoDev)
+	}
+	return f.dump(f.screen, true), nil
+}
+
+// RegionDump takes a snapshot of part of the screen, positioned like an image
+func (f *FBDev) RegionDump(x, y int16, w, h uint16, cfg *FBInkConfig) (*FBInkDump, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.mem == nil {
+		return nil, createError("fbink_region_dump", eNoDev)
+	}
+	p := f.grid.imageOrigin(f.screen, int(w), int(h), x, y, cfg)
+	r := image.Rectangle{Min: p, Max: p.Add(image.Pt(int(w), int(h)))}.Intersect(f.screen)
+	if r.Empty() {
+		return nil, createError("fbink_region_dump", eInval)
+	}
+	return f.dump(r, false), nil
+}
+
+// Restore puts a dump back where it was taken from, honoring its Clip
+func (f *FBDev) Restore(dump *FBInkDump, cfg *FBInkConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := dump.data.(goDumpData)
+	if dump.data == nil {
+		return createError("fbink_restore", eInval)
+	}
+	if !ok || f.mem == nil || uint32(dump.BPP) != f.vinfo.BitsPerPixel || uint32(dump.Rota) != f.vinfo.Rotate {
+		return createError("fbink_restore", eNotSup)
+	}
+	area := image.Rect(int(dump.Area.Left), int(dump.Area.Top), int(dump.Area.Left)+int(dump.Area.Width), int(dump.Area.Top)+int(dump.Area.Height))
+	if !area.In(f.screen) {
+		return createError("fbink_restore", eNotSup)
+	}
+	r := area
+	if dump.Clip.Width != 0 && dump.Clip.Height != 0 {
+		clip := image.Rect(int(dump.Clip.Left), int(dump.Clip.Top), int(dump.Clip.Left)+int(dump.Clip.Width), int(dump.Clip.Top)+int(dump.Clip.Height))
+		if !clip.In(f.screen) || !clip.Overlaps(area) {
+			return createError("fbink_restore", eNotSup)
+		}
+		r = area.Intersect(clip)
+	}
+	stride := int(dump.Stride)
+	n := r.Dx() * f.bypp
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		src := (y-area.Min.Y)*stride + (r.Min.X-area.Min.X)*f.bypp
+		off := f.offset(r.Min.X, y)
+		copy(f.mem[off:off+n], data[src:src+n])
+	}
+	return f.damage(r, cfg)
+}
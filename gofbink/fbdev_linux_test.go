@@ -0,0 +1,285 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var fbdevDepths = []uint32{8, 16, 32}
+
+// newTestFBDev returns an initialized file backed 160x240 framebuffer
+func newTestFBDev(t *testing.T, bpp uint32) (*FBDev, *FBInkConfig) {
+	t.Helper()
+	f := NewFBDevFile(filepath.Join(t.TempDir(), "fb"), 160, 240, bpp)
+	cfg := &FBInkConfig{}
+	if err := f.Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f, cfg
+}
+
+// grayAt reads back the (red channel of the) pixel at x, y
+func (f *FBDev) grayAt(x, y int) uint8 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, _, _ := f.unpack(f.load(f.offset(x, y)))
+	return r
+}
+
+func checkFBDevPixels(t *testing.T, f *FBDev, want map[image.Point]uint8) {
+	t.Helper()
+	for p, y := range want {
+		if got := f.grayAt(p.X, p.Y); got != y {
+			t.Errorf("%dbpp: pixel %v = %#x, want %#x", f.vinfo.BitsPerPixel, p, got, y)
+		}
+	}
+}
+
+func TestFBDevPack(t *testing.T) {
+	for _, bpp := range fbdevDepths {
+		f, _ := newTestFBDev(t, bpp)
+		for c := 0; c < 256; c++ {
+			r, g, b := f.unpack(f.pack(uint8(c), uint8(c), uint8(c)))
+			// RGB565 only keeps the top 5 (or 6) bits of each channel
+			tolerance := 0
+			if bpp == 16 {
+				tolerance = 8
+			}
+			for _, got := range []uint8{r, g, b} {
+				if d := int(got) - c; d < -tolerance || d > tolerance {
+					t.Errorf("%dbpp: %#x round-tripped to %#x, %#x, %#x", bpp, c, r, g, b)
+					break
+				}
+			}
+		}
+		// Black and white must survive any bitdepth exactly
+		for _, c := range []uint8{0x00, 0xFF} {
+			if r, g, b := f.unpack(f.pack(c, c, c)); r != c || g != c || b != c {
+				t.Errorf("%dbpp: %#x round-tripped to %#x, %#x, %#x", bpp, c, r, g, b)
+			}
+		}
+	}
+	// And every RGB565 pixel value is stable
+	f, _ := newTestFBDev(t, 16)
+	for v := uint32(0); v < 1<<16; v++ {
+		if got := f.pack(f.unpack(v)); got != v {
+			t.Fatalf("16bpp: %#04x round-tripped to %#04x", v, got)
+		}
+	}
+}
+
+func TestFBDevPrintRawData(t *testing.T) {
+	// A 4x2 black and white checkerboard, as Y and as RGBA
+	gray := []byte{0x00, 0xFF, 0x00, 0xFF, 0xFF, 0x00, 0xFF, 0x00}
+	rgba := make([]byte, 0, len(gray)*4)
+	for _, y := range gray {
+		rgba = append(rgba, y, y, y, 0xFF)
+	}
+	for _, bpp := range fbdevDepths {
+		for _, data := range [][]byte{gray, rgba} {
+			f, cfg := newTestFBDev(t, bpp)
+			if err := f.PrintRawData(data, 4, 2, 10, 20, cfg); err != nil {
+				t.Fatal(err)
+			}
+			checkFBDevPixels(t, f, map[image.Point]uint8{
+				{10, 20}: 0x00, {11, 20}: 0xFF, {13, 20}: 0xFF,
+				{10, 21}: 0xFF, {11, 21}: 0x00,
+				{14, 20}: 0x00, // Untouched (the file starts zeroed)
+			})
+			if got, want := f.LastRect(), fbRect(10, 20, 4, 2); got != want {
+				t.Errorf("%dbpp: last rect = %v, want %v", bpp, got, want)
+			}
+			if f.LastMarker() != 1 {
+				t.Errorf("%dbpp: marker = %d, want 1", bpp, f.LastMarker())
+			}
+			// Clipped by the edges of the screen
+			if err := f.PrintRawData(gray, 4, 2, 158, 239, cfg); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := f.LastRect(), fbRect(158, 239, 2, 1); got != want {
+				t.Errorf("%dbpp: clipped rect = %v, want %v", bpp, got, want)
+			}
+			checkFBDevPixels(t, f, map[image.Point]uint8{{158, 239}: 0x00, {159, 239}: 0xFF})
+			if err := f.PrintRawData(gray, 4, 2, 160, 0, cfg); !errors.Is(err, ErrInvalid) {
+				t.Errorf("%dbpp: off screen: err = %v, want ErrInvalid", bpp, err)
+			}
+		}
+	}
+}
+
+func TestFBDevClearScreen(t *testing.T) {
+	for _, bpp := range fbdevDepths {
+		f, cfg := newTestFBDev(t, bpp)
+		if err := f.ClearScreen(cfg, nil); err != nil {
+			t.Fatal(err)
+		}
+		checkFBDevPixels(t, f, map[image.Point]uint8{{0, 0}: 0xFF, {159, 239}: 0xFF})
+		cfg.IsInverted = true
+		if err := f.ClearScreen(cfg, &FBInkRect{Left: 10, Top: 20, Width: 30, Height: 40}); err != nil {
+			t.Fatal(err)
+		}
+		checkFBDevPixels(t, f, map[image.Point]uint8{
+			{10, 20}: 0x00, {39, 59}: 0x00,
+			{9, 20}: 0xFF, {40, 20}: 0xFF, {10, 60}: 0xFF,
+		})
+		if got, want := f.LastRect(), fbRect(10, 20, 30, 40); got != want {
+			t.Errorf("%dbpp: last rect = %v, want %v", bpp, got, want)
+		}
+		// Rects are clipped to the screen
+		if err := f.ClearScreen(cfg, &FBInkRect{Left: 150, Top: 230, Width: 30, Height: 40}); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := f.LastRect(), fbRect(150, 230, 10, 10); got != want {
+			t.Errorf("%dbpp: clipped rect = %v, want %v", bpp, got, want)
+		}
+	}
+}
+
+func TestFBDevRestore(t *testing.T) {
+	for _, bpp := range fbdevDepths {
+		f, cfg := newTestFBDev(t, bpp)
+		if err := f.ClearScreen(cfg, nil); err != nil {
+			t.Fatal(err)
+		}
+		black := make([]byte, 16*16)
+		if err := f.PrintRawData(black, 16, 16, 8, 8, cfg); err != nil {
+			t.Fatal(err)
+		}
+		full, err := f.Dump()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if full.BPP != uint8(bpp) || int(full.Stride) != 160*int(bpp)/8 {
+			t.Errorf("%dbpp: dump bpp %d, stride %d", bpp, full.BPP, full.Stride)
+		}
+		region, err := f.RegionDump(0, 0, 16, 16, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.ClearScreen(cfg, nil); err != nil {
+			t.Fatal(err)
+		}
+		// Only restore the bottom right quarter of the region
+		region.Clip = fbRect(12, 12, 100, 100)
+		if err := f.Restore(region, cfg); err != nil {
+			t.Fatal(err)
+		}
+		checkFBDevPixels(t, f, map[image.Point]uint8{
+			{8, 8}:   0xFF,
+			{12, 12}: 0x00,
+			{15, 15}: 0x00,
+			{16, 16}: 0xFF, // Out of the region
+		})
+		if got, want := f.LastRect(), fbRect(12, 12, 4, 4); got != want {
+			t.Errorf("%dbpp: restored rect = %v, want %v", bpp, got, want)
+		}
+		if err := f.Restore(full, cfg); err != nil {
+			t.Fatal(err)
+		}
+		checkFBDevPixels(t, f, map[image.Point]uint8{{7, 7}: 0xFF, {8, 8}: 0x00, {23, 23}: 0x00, {24, 24}: 0xFF})
+		full.Free()
+		if err := f.Restore(full, cfg); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%dbpp: freed dump: err = %v, want ErrInvalid", bpp, err)
+		}
+	}
+	// Dumps can't be restored at another bitdepth
+	f8, cfg := newTestFBDev(t, 8)
+	f32, _ := newTestFBDev(t, 32)
+	dump, err := f32.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f8.Restore(dump, cfg); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("32bpp dump on 8bpp: err = %v, want ErrNotSupported", err)
+	}
+}
+
+func TestFBDevReInit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fb")
+	f := NewFBDevFile(path, 160, 240, 8)
+	defer f.Close()
+	cfg := &FBInkConfig{}
+	// ReInit before Init initializes
+	if change, err := f.ReInit(cfg); err != nil || change.Changed() {
+		t.Fatalf("first ReInit: change %v, err %v", change, err)
+	}
+	if change, err := f.ReInit(cfg); err != nil || change.Changed() {
+		t.Fatalf("unchanged ReInit: change %v, err %v", change, err)
+	}
+	steps := []struct {
+		name   string
+		fake   *fbVarScreenInfo
+		change ReinitChange
+		bpp    uint32
+		w, h   uint32
+	}{
+		{"bitdepth", NewFBDevFile(path, 160, 240, 32).fake, BPPChanged, 32, 160, 240},
+		{"layout", NewFBDevFile(path, 240, 160, 32).fake, LayoutChanged, 32, 240, 160},
+		{"both", NewFBDevFile(path, 160, 240, 16).fake, BPPChanged | LayoutChanged, 16, 160, 240},
+	}
+	for _, s := range steps {
+		f.fake = s.fake
+		change, err := f.ReInit(cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if change != s.change {
+			t.Errorf("%s: change = %v, want %v", s.name, change, s.change)
+		}
+		var state FBInkState
+		f.State(cfg, &state)
+		if state.BPP != s.bpp || state.ScreenWidth != s.w || state.ScreenHeight != s.h {
+			t.Errorf("%s: state %dbpp %dx%d, want %dbpp %dx%d", s.name,
+				state.BPP, state.ScreenWidth, state.ScreenHeight, s.bpp, s.w, s.h)
+		}
+		// The new mapping is usable, and covers the whole screen
+		if err := f.ClearScreen(cfg, nil); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		checkFBDevPixels(t, f, map[image.Point]uint8{{int(s.w) - 1, int(s.h) - 1}: 0xFF})
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 160*240*2 {
+		t.Errorf("file size = %d, want %d", fi.Size(), 160*240*2)
+	}
+}
+
+func TestFBDevNoFonts(t *testing.T) {
+	f, cfg := newTestFBDev(t, 32)
+	if _, err := f.Print("Hello", cfg); !errors.Is(err, ErrNotImplemented) {
+		t.Errorf("Print: err = %v, want ErrNotImplemented", err)
+	}
+	if _, _, err := f.PrintOT("Hello", &FBInkOTConfig{}, cfg); !errors.Is(err, ErrNotImplemented) {
+		t.Errorf("PrintOT: err = %v, want ErrNotImplemented", err)
+	}
+}
@@ -23,19 +23,9 @@
 
 package gofbink
 
-// #cgo LDFLAGS: -L${SRCDIR}/../fbinklib -lfbink -lm
-// #include <stdlib.h>
-// #include <errno.h>
-// #include "fbink.h"
-import "C"
 import (
-	"container/list"
-	"errors"
-	"fmt"
 	"image"
-	"strings"
-	"unicode/utf8"
-	"unsafe"
+	"sync"
 )
 
 // Font type
@@ -211,31 +201,30 @@ const (
 type CexitCode int
 
 // Go translation of FBInk's exit codes
+// NOTE: FBInk only runs on Linux, so these are Linux's errno values
 const (
-	exitSuccess            = CexitCode(C.EXIT_SUCCESS)
-	exitOkBitdepthChange   = CexitCode(C.OK_BPP_CHANGE)
-	exitOkRotaChange       = CexitCode(C.OK_ROTA_CHANGE)
-	exitOkLayoutChange     = CexitCode(C.OK_LAYOUT_CHANGE)
-	exitOkSameColorAlready = CexitCode(C.OK_ALREADY_SAME)
-	exitFailure            = CexitCode(C.EXIT_FAILURE) * -1
-	eNoDev                 = CexitCode(C.ENODEV) * -1
-	eNotSup                = CexitCode(C.ENOTSUP) * -1
-	eNoData                = CexitCode(C.ENODATA) * -1
-	eTime                  = CexitCode(C.ETIME) * -1
-	eInval                 = CexitCode(C.EINVAL) * -1
-	eIlSeq                 = CexitCode(C.EILSEQ) * -1
-	eRange                 = CexitCode(C.ERANGE) * -1
-	eNoSpc                 = CexitCode(C.ENOSPC) * -1
-	eNoSys                 = CexitCode(C.ENOSYS) * -1
+	exitSuccess            = CexitCode(0)
+	exitOkBitdepthChange   = CexitCode(1 << 9)
+	exitOkRotaChange       = CexitCode(1 << 10)
+	exitOkLayoutChange     = CexitCode(1 << 11)
+	exitOkSameColorAlready = CexitCode(1 << 9)
+	exitFailure            = CexitCode(1) * -1
+	eNoDev                 = CexitCode(19) * -1
+	eNotSup                = CexitCode(95) * -1
+	eNoData                = CexitCode(61) * -1
+	eTime                  = CexitCode(62) * -1
+	eInval                 = CexitCode(22) * -1
+	eIlSeq                 = CexitCode(84) * -1
+	eRange                 = CexitCode(34) * -1
+	eNoSpc                 = CexitCode(28) * -1
+	eNoSys                 = CexitCode(38) * -1
 )
 
 // FBFDauto is the automatic fbfd handler
-const FBFDauto = int(C.FBFD_AUTO)
+const FBFDauto = -1
 
 // LastMarker is an automatic previous marker retrieval for use with WaitFor*
-const LastMarker = uint32(C.LAST_MARKER)
-
-// const exitSuccess = int(C.EXIT_SUCCESS)
+const LastMarker = uint32(0)
 
 // FBInkState stores a snapshot of some of FBInk's internal variables
 type FBInkState struct {
@@ -309,11 +298,30 @@ type FBInkConfig struct {
 	IsNightmode   bool
 	NoRefresh     bool
 	toSyslog      bool
+	// GoDither dithers images in Go, before they're handed to FBInk, so
+	// that they look the same on every device (see the dither package).
+	// Only the gray levels are dithered, the alpha channel is kept as is.
+	// It applies to PrintGoImage.
+	GoDither Ditherer
+	// GoScale scales images in Go, with GoFilter, before they're handed to
+	// FBInk, which gives much better results than ScaledWidth &
+	// ScaledHeight. The target is ScaledWidth x ScaledHeight when they're
+	// positive, the viewport otherwise. It applies to PrintGoImage.
+	GoScale  ScaleMode
+	GoFilter Filter
+}
+
+// Ditherer quantizes images to the gray levels of eInk panels.
+// It's implemented by dither.Ditherer.
+type Ditherer interface {
+	Dither(im image.Image) *image.Gray
 }
 
 // FBInkOTConfig is a struct which configures OpenType specific options
 type FBInkOTConfig struct {
-	//Font         unsafe.Pointer
+	// Fonts is an optional font set private to this config.
+	// If nil, the global pool set up via AddOTfont is used.
+	Fonts   *OTFontSet
 	Margins struct {
 		Top    int16
 		Bottom int16
@@ -345,9 +353,10 @@ type FBInkRect struct {
 	Height uint16
 }
 
-// FBInkDump for use with fump & restore
+// FBInkDump for use with Dump, RegionDump & Restore
+// Clip (and IsFull) are the only fields you should ever modify yourself
 type FBInkDump struct {
-	data   *uint8
+	data   dumpData
 	Stride uint
 	Size   uint
 	Area   FBInkRect
@@ -371,124 +380,49 @@ type RestrictedConfig struct {
 	ToSyslog   bool
 }
 
-func createError(retValue CexitCode) error {
-	switch retValue {
-	case exitFailure:
-		return errors.New("EXIT_FAILURE")
-	case eNoDev:
-		return errors.New("ENODEV")
-	case eNotSup:
-		return errors.New("ENOTSUP")
-	case eNoData:
-		return errors.New("ENODATA")
-	case eTime:
-		return errors.New("ETIME")
-	case eInval:
-		return errors.New("EINVAL")
-	case eIlSeq:
-		return errors.New("EILSEQ")
-	case eRange:
-		return errors.New("ERANGE")
-	case eNoSpc:
-		return errors.New("ENOSPC")
-	case eNoSys:
-		return errors.New("ENOSYS")
-	default:
-		return nil
-	}
-}
-
 // FBInk contains the active FBInk seesion
 type FBInk struct {
-	internCfg        FBInkConfig
-	fbfd             C.int
-	lines            *list.List
-	totalRowsWritten int16
+	internCfg FBInkConfig
+	backend   Backend
+	console   *Console
+	queueMu   sync.RWMutex
+	queue     chan *renderOp
+	watcher   *Watcher // Only ever touched by the render goroutine
+	headers   int      // Amount of status lines pinned to the top
+	footers   int      // and to the bottom
 }
 
 // New creates an fbInker pointer which clients can
 // use to interact with the eink framebuffer
 func New(cfg *FBInkConfig, rCfg *RestrictedConfig) *FBInk {
+	return NewWithBackend(newDefaultBackend(), cfg, rCfg)
+}
+
+// NewWithBackend is New, but drawing through the given Backend instead of
+// libfbink. See NewVirtualFB for a backend that doesn't need a device.
+func NewWithBackend(b Backend, cfg *FBInkConfig, rCfg *RestrictedConfig) *FBInk {
 	f := &FBInk{}
-	f.fbfd = C.FBFD_AUTO
+	f.backend = b
 	f.UpdateRestricted(cfg, rCfg)
 	f.internCfg.Row = 1
 	f.internCfg.Col = 1
-	f.lines = list.New()
-	f.lines.PushBack(" ")
+	f.console = f.NewConsole(DefaultConsoleConfig)
 	return f
 }
 
-func (f *FBInk) newConfigC(cfg *FBInkConfig) C.FBInkConfig {
-	var cfgC C.FBInkConfig
-	cfgC.row = C.short(cfg.Row)
-	cfgC.col = C.short(cfg.Col)
-	cfgC.fontmult = C.uint8_t(cfg.fontmult)
-	cfgC.fontname = C.uint8_t(cfg.fontname)
-	cfgC.is_inverted = C.bool(cfg.IsInverted)
-	cfgC.is_flashing = C.bool(cfg.IsFlashing)
-	cfgC.is_cleared = C.bool(cfg.IsCleared)
-	cfgC.is_centered = C.bool(cfg.isCentered)
-	cfgC.hoffset = C.short(cfg.Hoffset)
-	cfgC.voffset = C.short(cfg.Voffset)
-	cfgC.is_halfway = C.bool(cfg.IsHalfway)
-	cfgC.is_padded = C.bool(cfg.IsPadded)
-	cfgC.is_rpadded = C.bool(cfg.IsRpadded)
-	cfgC.fg_color = C.uint8_t(cfg.fgColor)
-	cfgC.bg_color = C.uint8_t(cfg.bgColor)
-	cfgC.is_overlay = C.bool(cfg.IsOverlay)
-	cfgC.is_bgless = C.bool(cfg.IsBGless)
-	cfgC.is_fgless = C.bool(cfg.isFGless)
-	cfgC.no_viewport = C.bool(cfg.noViewport)
-	cfgC.is_verbose = C.bool(cfg.isVerbose)
-	cfgC.is_quiet = C.bool(cfg.isQuiet)
-	cfgC.ignore_alpha = C.bool(cfg.IgnoreAlpha)
-	cfgC.halign = C.uint8_t(cfg.Halign)
-	cfgC.valign = C.uint8_t(cfg.Valign)
-	cfgC.scaled_width = C.short(cfg.ScaledWidth)
-	cfgC.scaled_height = C.short(cfg.ScaledHeight)
-	cfgC.wfm_mode = C.uint8_t(cfg.WfmMode)
-	cfgC.dithering_mode = C.uint8_t(cfg.DitheringMode)
-	cfgC.sw_dithering = C.bool(cfg.SWDithering)
-	cfgC.is_nightmode = C.bool(cfg.IsNightmode)
-	cfgC.no_refresh = C.bool(cfg.NoRefresh)
-	cfgC.to_syslog = C.bool(cfg.toSyslog)
-	return cfgC
-}
-
-func (f *FBInk) newOTConfig(otCfg *FBInkOTConfig) C.FBInkOTConfig {
-	var otCfgC C.FBInkOTConfig
-	// Opaque pointer, should be considered mostly private, unless attempting tricky stuff with the _v2 API calls
-	//otCfgC.font = unsafe.Pointer(otCfg.Font)
-	// We're not using the _v2 ot_font API, so, just nil it to keep using the global font pool
-	otCfgC.font = nil
-	otCfgC.margins.top = C.short(otCfg.Margins.Top)
-	otCfgC.margins.bottom = C.short(otCfg.Margins.Bottom)
-	otCfgC.margins.left = C.short(otCfg.Margins.Left)
-	otCfgC.margins.right = C.short(otCfg.Margins.Right)
-	otCfgC.style = C.int(otCfg.Style)
-	otCfgC.size_pt = C.float(otCfg.SizePt)
-	otCfgC.size_px = C.uint16_t(otCfg.SizePx)
-	otCfgC.is_centered = C.bool(otCfg.IsCentred)
-	otCfgC.padding = C.uint8_t(otCfg.Padding)
-	otCfgC.is_formatted = C.bool(otCfg.IsFormatted)
-	otCfgC.compute_only = C.bool(otCfg.ComputeOnly)
-	otCfgC.no_truncation = C.bool(otCfg.NoTruncation)
-	return otCfgC
-}
-
-func (f *FBInk) newRect(Rect *FBInkRect) C.FBInkRect {
-	var rectC C.FBInkRect
-	rectC.left = C.uint16_t(Rect.Left)
-	rectC.top = C.uint16_t(Rect.Top)
-	rectC.width = C.uint16_t(Rect.Width)
-	rectC.height = C.uint16_t(Rect.Height)
-	return rectC
+// Backend returns the Backend the session draws through
+// NOTE: Calling it directly bypasses the session's render queue
+func (f *FBInk) Backend() Backend {
+	return f.backend
 }
 
 // UpdateRestricted updates cfg with the values in rCfg, which is
 // followed by a call to Init()
 func (f *FBInk) UpdateRestricted(cfg *FBInkConfig, rCfg *RestrictedConfig) {
+	f.do(func() { f.updateRestricted(cfg, rCfg) })
+}
+
+func (f *FBInk) updateRestricted(cfg *FBInkConfig, rCfg *RestrictedConfig) {
 	cfg.fontmult = rCfg.Fontmult
 	f.internCfg.fontmult = rCfg.Fontmult
 	cfg.fontname = rCfg.Fontname
@@ -507,353 +441,162 @@ func (f *FBInk) UpdateRestricted(cfg *FBInkConfig, rCfg *RestrictedConfig) {
 	f.internCfg.isVerbose = rCfg.IsVerbose
 	cfg.toSyslog = rCfg.ToSyslog
 	f.internCfg.toSyslog = rCfg.ToSyslog
-	f.Init(cfg)
-}
-
-// Version gets the fbink version
-func (f *FBInk) Version() string {
-	vers := C.GoString(C.fbink_version())
-	return vers
+	f.backend.Init(cfg)
 }
 
-// Open the framebuffer device and stores its fd
-func (f *FBInk) Open() {
-	// Only open if we haven't already obtained a file descriptor
-	if f.fbfd == C.FBFD_AUTO {
-		f.fbfd = C.fbink_open()
-	}
+// Open the framebuffer device and keeps it open until Close
+// Without it, the device is opened for the duration of every call
+func (f *FBInk) Open() (err error) {
+	f.do(func() { err = f.backend.Open() })
+	return err
 }
 
 // Close unmaps the framebuffer and closes the file descripter
+// It also stops the session's render goroutine (and Watcher), the former
+// being restarted if the session is used again
 func (f *FBInk) Close() (err error) {
-	err = nil
-	// Nothing to do unless we obtained a file descriptor!
-	if f.fbfd != C.FBFD_AUTO {
-		res := CexitCode(C.fbink_close(f.fbfd))
-		err = createError(res)
+	var w *Watcher
+	f.do(func() { w = f.watcher })
+	if w != nil {
+		w.Stop()
 	}
+	f.do(func() { err = f.backend.Close() })
+	f.stop()
 	return err
 }
 
 // Init initializes the fbink global variables
 // See "fbink.h" for detailed usage and explanation
-func (f *FBInk) Init(cfg *FBInkConfig) error {
-	cfgC := f.newConfigC(cfg)
-	res := CexitCode(C.fbink_init(f.fbfd, &cfgC))
-	return createError(res)
-}
-
-// AddOTfont registers an OpenType or TrueType font with FBInk
-// At least one font needs to be specified to use the OT print function
-// See "fbink.h" for detailed usage and explanation
-func (f *FBInk) AddOTfont(filename string, fntStyle FontStyle) error {
-	fnC := C.CString(filename)
-	defer C.free(unsafe.Pointer(fnC))
-	res := CexitCode(C.fbink_add_ot_font(fnC, C.FONT_STYLE_T(fntStyle)))
-	return createError(res)
-}
-
-// FreeOTfonts frees any loaded OT font. This MUST be called at the
-// conclusion of OT printing, to avoid memory leaks
-func (f *FBInk) FreeOTfonts() error {
-	res := CexitCode(C.fbink_free_ot_fonts())
-	return createError(res)
+func (f *FBInk) Init(cfg *FBInkConfig) (err error) {
+	f.do(func() { err = f.backend.Init(cfg) })
+	return err
 }
 
 // GetState dumps a lot of FBInk internal variables
 func (f *FBInk) GetState(cfg *FBInkConfig, state *FBInkState) {
-	cfgC := f.newConfigC(cfg)
-	stateC := C.FBInkState{}
-	C.fbink_get_state(&cfgC, &stateC)
-	state.UserHZ = int(stateC.user_hz)
-	state.FontName = C.GoString(stateC.font_name)
-	state.ViewWidth = uint32(stateC.view_width)
-	state.ViewHeight = uint32(stateC.view_height)
-	state.ScreenWidth = uint32(stateC.screen_width)
-	state.ScreenHeight = uint32(stateC.screen_height)
-	state.BPP = uint32(stateC.bpp)
-	state.DeviceName = C.GoString(&stateC.device_name[0])
-	state.DeviceCodename = C.GoString(&stateC.device_codename[0])
-	state.DevicePlatform = C.GoString(&stateC.device_platform[0])
-	state.DeviceID = uint16(stateC.device_id)
-	state.PenFGcolor = uint8(stateC.pen_fg_color)
-	state.PenBGcolor = uint8(stateC.pen_bg_color)
-	state.ScreenDPI = uint16(stateC.screen_dpi)
-	state.FontW = uint16(stateC.font_w)
-	state.FontH = uint16(stateC.font_h)
-	state.MaxCols = uint16(stateC.max_cols)
-	state.MaxRows = uint16(stateC.max_rows)
-	state.ViewHoriOrigin = uint8(stateC.view_hori_origin)
-	state.ViewVertOrigin = uint8(stateC.view_vert_origin)
-	state.ViewVertOffset = uint8(stateC.view_vert_offset)
-	state.FontSizeMult = uint8(stateC.fontsize_mult)
-	state.GlyphWidth = uint8(stateC.glyph_width)
-	state.GlyphHeight = uint8(stateC.glyph_height)
-	state.IsPerfectFit = bool(stateC.is_perfect_fit)
-	state.IsPBSunxi = bool(stateC.is_pb_sunxi)
-	state.IsKindleLegacy = bool(stateC.is_kindle_legacy)
-	state.IsKoboNonMT = bool(stateC.is_kobo_non_mt)
-	state.NTXBootRota = uint8(stateC.ntx_boot_rota)
-	state.NTXRotaQuirk = NTXRota(stateC.ntx_rota_quirk)
-	state.IsNTXQuirkyLandscape = bool(stateC.is_ntx_quirky_landscape)
-	state.CurrentRota = uint8(stateC.current_rota)
-	state.CanRotate = bool(stateC.can_rotate)
-	state.CanHWInvert = bool(stateC.can_hw_invert)
+	f.do(func() { f.backend.State(cfg, state) })
 }
 
 // FBprint prints a string to the screen
 // See "fbink.h" for detailed usage and explanation
 func (f *FBInk) FBprint(str string, cfg *FBInkConfig) (rows int, err error) {
-	cfgC := f.newConfigC(cfg)
-	strC := C.CString(str)
-	defer C.free(unsafe.Pointer(strC))
-	rows = int(C.fbink_print(f.fbfd, strC, &cfgC))
-	return rows, createError(CexitCode(rows))
+	f.do(func() { rows, err = f.backend.Print(str, cfg) })
+	return rows, err
 }
 
 // PrintOT prints a string to the framebuffer using OpenType or TrueType fonts
+// It returns the new top margin, along with details about the line-breaking
+// computations (which are also filled in when ComputeOnly is set)
 // See "fbink.h" for detailed usage and explanation
-func (f *FBInk) PrintOT(str string, otCfg *FBInkOTConfig, fbCfg *FBInkConfig) (int, error) {
-	fbCfgC := f.newConfigC(fbCfg)
-	otCfgC := f.newOTConfig(otCfg)
-	strC := C.CString(str)
-	defer C.free(unsafe.Pointer(strC))
-	res := C.fbink_print_ot(f.fbfd, strC, &otCfgC, &fbCfgC, nil)
-	return int(res), createError(CexitCode(res))
+func (f *FBInk) PrintOT(str string, otCfg *FBInkOTConfig, fbCfg *FBInkConfig) (top int, fit FBInkOTFit, err error) {
+	f.do(func() { top, fit, err = f.backend.PrintOT(str, otCfg, fbCfg) })
+	return top, fit, err
 }
 
 // Println prints to the screen in the manner of calling fmt.Println()
-// Output appears as a set of scrolling lines
+// Output appears as a set of scrolling lines, in the default Console
 func (f *FBInk) Println(a ...interface{}) (n int, err error) {
-	str := fmt.Sprint(a...)
-	n = len([]byte(str))
-	if f.lines.Len() > 8 {
-		l := f.lines.Front()
-		f.lines.Remove(l)
-	}
-	f.lines.PushBack(str)
-	fbStr := ""
-	f.internCfg.Row = 4
-	state := FBInkState{}
-	f.GetState(&f.internCfg, &state)
-	for line := f.lines.Front(); line != nil; line = line.Next() {
-		fbStr = line.Value.(string)
-		strLen := utf8.RuneCountInString(fbStr)
-		numRows := strLen / (int(state.MaxCols) - 1)
-		for i := 0; i <= numRows; i++ {
-			space := strings.Repeat(" ", (int(state.MaxCols) - 1))
-			f.FBprint(space, &f.internCfg)
-		}
-		r, _ := f.FBprint(fbStr, &f.internCfg)
-		f.internCfg.Row += int16(r)
-	}
-	if f.internCfg.Row > f.totalRowsWritten {
-		f.totalRowsWritten = f.internCfg.Row
-	} else if f.internCfg.Row < f.totalRowsWritten {
-		row := f.internCfg.Row
-		diff := f.totalRowsWritten - row
-		for i := row; i < row+diff; i++ {
-			f.internCfg.Row = i
-			fbStr = " "
-			f.FBprint(fbStr, &f.internCfg)
-		}
-	}
-	return n, err
+	return f.console.Println(a...)
 }
 
 // PrintLastLn replaces the last line in the output, without scrolling
 func (f *FBInk) PrintLastLn(a ...interface{}) (n int, err error) {
-	str := fmt.Sprint(a...)
-	n = len([]byte(str))
-	l := f.lines.Back()
-	f.lines.Remove(l)
-	f.lines.PushBack(str)
-	fbStr := ""
-	f.internCfg.Row = 4
-	for line := f.lines.Front(); line != nil; line = line.Next() {
-		fbStr = line.Value.(string)
-		r, _ := f.FBprint(fbStr, &f.internCfg)
-		f.internCfg.Row += int16(r)
-	}
-	return n, err
+	return f.console.PrintLastLn(a...)
 }
 
 // Refresh provides a way of refreshing the eink screen
 // See "fbink.h" for detailed usage and explanation
-func (f *FBInk) Refresh(top, left, width, height uint32, cfg *FBInkConfig) error {
-	cfgC := f.newConfigC(cfg)
-	topC := C.uint32_t(top)
-	leftC := C.uint32_t(left)
-	widthC := C.uint32_t(width)
-	heightC := C.uint32_t(height)
-	res := CexitCode(C.fbink_refresh(f.fbfd, topC, leftC, widthC, heightC, &cfgC))
-	return createError(res)
+func (f *FBInk) Refresh(top, left, width, height uint32, cfg *FBInkConfig) (err error) {
+	f.do(func() { err = f.backend.Refresh(top, left, width, height, cfg) })
+	return err
 }
 
 // WaitForSubmission waits for the submission of a specific refresh (Kindle only)
+// It doesn't go through the render queue, so it doesn't block other drawing
 // See "fbink.h" for detailed usage and explanation
 func (f *FBInk) WaitForSubmission(marker uint32) error {
-	markerC := C.uint32_t(marker)
-	res := CexitCode(C.fbink_wait_for_submission(f.fbfd, markerC))
-	return createError(res)
+	return f.backend.WaitForSubmission(marker)
 }
 
 // WaitForCompletion waits for the completion of a specific refresh
+// It doesn't go through the render queue, so it doesn't block other drawing
 // See "fbink.h" for detailed usage and explanation
 func (f *FBInk) WaitForCompletion(marker uint32) error {
-	markerC := C.uint32_t(marker)
-	res := CexitCode(C.fbink_wait_for_complete(f.fbfd, markerC))
-	return createError(res)
+	return f.backend.WaitForCompletion(marker)
 }
 
 // GetLastMarker returns the marker from the last refresh sent
 // See "fbink.h" for detailed usage and explanation
 func (f *FBInk) GetLastMarker() (uint32, error) {
-	res := C.fbink_get_last_marker()
-	return uint32(res), createError(CexitCode(res))
+	// This one can't fail, it returns LastMarker if there wasn't any refresh
+	var marker uint32
+	f.do(func() { marker = f.backend.LastMarker() })
+	return marker, nil
 }
 
-// // IsFBquirky tests for a quirky framebuffer state
-// // See "fbink.h" for detailed usage and explanation
-// func (f *FBInk) IsFBquirky() bool {
-// 	var resultC C.bool
-// 	resultC = C.fbink_is_fb_quirky()
-// 	return bool(resultC)
-// }
-
 // ReInit handles cases where the framebuffer state such as bit depth
-// or rotation may change
-// See "fbink.h" for detailed usage and explanation
-func (f *FBInk) ReInit(cfg *FBInkConfig) error {
-	cfgC := f.newConfigC(cfg)
-	res := CexitCode(C.fbink_reinit(f.fbfd, &cfgC))
-	return createError(res)
-}
-
-// PrintProgressBar displays a full width progress bar
-// NOTE: percentage should be a number between 0 - 100
-// See "fbink.h" for detailed usage and explanation
-func (f *FBInk) PrintProgressBar(percentage uint8, cfg *FBInkConfig) error {
-	cfgC := f.newConfigC(cfg)
-	percentC := C.uint8_t(percentage)
-	res := CexitCode(C.fbink_print_progress_bar(f.fbfd, percentC, &cfgC))
-	return createError(res)
-}
-
-// PrintActivityBar displays a full width activity bar
-// NOTE: progress should be a number between 0 - 19.
-//       where 0 enables an infinite activity bar!
+// or rotation may change. It reports what changed, if anything, so the
+// caller knows when its layout (and FBInkState copy) may be stale
 // See "fbink.h" for detailed usage and explanation
-func (f *FBInk) PrintActivityBar(progress uint8, cfg *FBInkConfig) error {
-	cfgC := f.newConfigC(cfg)
-	progressC := C.uint8_t(progress)
-	res := CexitCode(C.fbink_print_activity_bar(f.fbfd, progressC, &cfgC))
-	return createError(res)
+func (f *FBInk) ReInit(cfg *FBInkConfig) (change ReinitChange, err error) {
+	f.do(func() {
+		change, err = f.backend.ReInit(cfg)
+		if change.Changed() && f.watcher != nil {
+			f.watcher.publish(change)
+		}
+	})
+	return change, err
 }
 
 // PrintImage will print an image to the screen
 // See "fbink.h" for detailed usage and explanation
-func (f *FBInk) PrintImage(imgPath string, targX, targY int16, cfg *FBInkConfig) error {
-	cfgC := f.newConfigC(cfg)
-	imgPathC := C.CString(imgPath)
-	defer C.free(unsafe.Pointer(imgPathC))
-	xC := C.short(targX)
-	yC := C.short(targY)
-	res := CexitCode(C.fbink_print_image(f.fbfd, imgPathC, xC, yC, &cfgC))
-	return createError(res)
+func (f *FBInk) PrintImage(imgPath string, targX, targY int16, cfg *FBInkConfig) (err error) {
+	f.do(func() { err = f.backend.PrintImage(imgPath, targX, targY, cfg) })
+	return err
 }
 
 // PrintRawData prints raw scanlines to the screen, without having to save image
 // to disk beforehand. Useful for images created programatically.
 // See "fbink.h" for detailed usage and explanation
-func (f *FBInk) PrintRawData(data []byte, w, h int, xOff, yOff uint16, cfg *FBInkConfig) error {
-	cfgC := f.newConfigC(cfg)
-	res := CexitCode(C.fbink_print_raw_data(
-		f.fbfd,
-		(*C.uchar)(unsafe.Pointer(&data[0])),
-		C.int(w),
-		C.int(h),
-		C.size_t(len(data)),
-		C.short(xOff),
-		C.short(yOff),
-		&cfgC))
-	return createError(res)
+func (f *FBInk) PrintRawData(data []byte, w, h int, xOff, yOff uint16, cfg *FBInkConfig) (err error) {
+	f.do(func() { err = f.backend.PrintRawData(data, w, h, int16(xOff), int16(yOff), cfg) })
+	return err
 }
 
 // PrintRBGA prints an image stored in an image.RGBA
+//
+// Deprecated: Use PrintGoImage, which takes any image.Image
 func (f *FBInk) PrintRBGA(xOff, yOff int16, im *image.RGBA, cfg *FBInkConfig) error {
-	cfgC := f.newConfigC(cfg)
-	w := im.Rect.Max.X - im.Rect.Min.X
-	h := im.Rect.Max.Y - im.Rect.Min.Y
-	res := CexitCode(C.fbink_print_raw_data(
-		f.fbfd,
-		(*C.uchar)(unsafe.Pointer(&im.Pix[0])),
-		C.int(w),
-		C.int(h),
-		C.size_t(im.Stride*h),
-		C.short(xOff),
-		C.short(yOff),
-		&cfgC))
-	return createError(res)
+	return f.PrintGoImage(xOff, yOff, im, cfg)
 }
 
 // GetLastRect returns the last painted to area
 // See "fbink.h" for detailed usage and explanation
-func (f *FBInk) GetLastRect() FBInkRect {
-	rectC := C.fbink_get_last_rect()
-	return FBInkRect{
-		Left:   uint16(rectC.left),
-		Top:    uint16(rectC.top),
-		Width:  uint16(rectC.width),
-		Height: uint16(rectC.height),
-	}
+func (f *FBInk) GetLastRect() (rect FBInkRect) {
+	f.do(func() { rect = f.backend.LastRect() })
+	return rect
 }
 
 // ClearScreen simply clears the screen to white
 // See "fbink.h" for detailed usage and explanation
-func (f *FBInk) ClearScreen(cfg *FBInkConfig, rect *FBInkRect) error {
-	cfgC := f.newConfigC(cfg)
-	rectC := f.newRect(rect)
-	res := CexitCode(C.fbink_cls(f.fbfd, &cfgC, &rectC))
-	return createError(res)
-}
-
-// RotaNativeToCanonical attempts to convert a native vInfo rotate constant to a canonical representation
-// See "fbink.h" for detailed usage and explanation
-func (f *FBInk) RotaNativeToCanonical(rotate uint32) (uint8) {
-	res := C.fbink_rota_native_to_canonical(C.uint32_t(rotate))
-	return uint8(res)
-}
-
-// RotaCanonicalToNative attempts to convert a canonical representation to a native vInfo rotate constant
-// See "fbink.h" for detailed usage and explanation
-func (f *FBInk) RotaCanonicalToNative(rotate uint8) (uint32) {
-	res := C.fbink_rota_canonical_to_native(C.uint8_t(rotate))
-	return uint32(res)
+func (f *FBInk) ClearScreen(cfg *FBInkConfig, rect *FBInkRect) (err error) {
+	f.do(func() { err = f.backend.ClearScreen(cfg, rect) })
+	return err
 }
 
-// TODO: fbink_dump, fbink_region_dump, fbink_restore, fbink_free_dump_data
-// TODO: fbink_update_verbosity, fbink_update_pen_colors
-//       (which don't make much sense given the RestrictedConfig concept here ;)).
-// TODO: fbink_set_fg_pen_gray, fbink_set_bg_pen_gray, fbink_set_fg_pen_rgba, fbink_set_bg_pen_rgba
-// TODO: fbink_grid_clear, fbink_grid_refresh
-// TODO: fbink_add_ot_font_v2, fbink_free_ot_fonts_v2
-//       (don't really fit with the current API ;))
-
-// ButtonScan will scan for the 'Connect' button on the Kobo USB connect screen
+// GridClear clears a block of cols x rows text cells, positioned like
+// FBprint would (i.e., honoring Row/Col, Hoffset/Voffset, IsHalfway,
+// IsCentered, IsPadded & IsRpadded)
 // See "fbink.h" for detailed usage and explanation
-func (f *FBInk) ButtonScan(pressButton, noSleep bool) error {
-	pressBtnC := C.bool(pressButton)
-	noSleepC := C.bool(noSleep)
-	res := CexitCode(C.fbink_button_scan(f.fbfd, pressBtnC, noSleepC))
-	return createError(res)
+func (f *FBInk) GridClear(cols, rows uint16, cfg *FBInkConfig) (err error) {
+	f.do(func() { err = f.backend.GridClear(cols, rows, cfg) })
+	return err
 }
 
-// WaitForUSBMSprocessing waits for the end of a kobo USBMS session
-// It also tries to detect a succesful content import
+// GridRefresh refreshes a block of cols x rows text cells, positioned
+// like FBprint would. Like Refresh, this ignores NoRefresh
 // See "fbink.h" for detailed usage and explanation
-func (f *FBInk) WaitForUSBMSprocessing(forceUnplug bool) error {
-	forceUnplugC := C.bool(forceUnplug)
-	res := CexitCode(C.fbink_wait_for_usbms_processing(f.fbfd, forceUnplugC))
-	return createError(res)
+func (f *FBInk) GridRefresh(cols, rows uint16, cfg *FBInkConfig) (err error) {
+	f.do(func() { err = f.backend.GridRefresh(cols, rows, cfg) })
+	return err
 }
@@ -0,0 +1,280 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"io/fs"
+)
+
+// ImageCheck vets an image from its header, before it's decoded (eg: to
+// turn down images that are too large). The format is the name it was
+// registered with (eg: "png").
+type ImageCheck func(cfg image.Config, format string) error
+
+// PrintImageFrom decodes an image from r with the registered Go decoders
+// (PNG, JPEG, GIF & BMP, plus any other registered with image.RegisterFormat),
+// and prints it with PrintGoImage. Unlike PrintImage, it doesn't need a file.
+// Each of the checks is run on the image header beforehand, and decoding is
+// aborted with the first error returned.
+func (f *FBInk) PrintImageFrom(r io.Reader, xOff, yOff int16, cfg *FBInkConfig, checks ...ImageCheck) error {
+	im, err := decodeImage(r, checks)
+	if err != nil {
+		return err
+	}
+	return f.PrintGoImage(xOff, yOff, im, cfg)
+}
+
+// PrintImageBytes is PrintImageFrom for an image in memory
+func (f *FBInk) PrintImageBytes(data []byte, xOff, yOff int16, cfg *FBInkConfig, checks ...ImageCheck) error {
+	return f.PrintImageFrom(bytes.NewReader(data), xOff, yOff, cfg, checks...)
+}
+
+// PrintImageFS is PrintImageFrom for an image of a fs.FS, such as an
+// embed.FS, which allows shipping images inside the binary
+func (f *FBInk) PrintImageFS(fsys fs.FS, name string, xOff, yOff int16, cfg *FBInkConfig, checks ...ImageCheck) error {
+	fh, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return f.PrintImageFrom(fh, xOff, yOff, cfg, checks...)
+}
+
+// decodeImage decodes an image, after vetting its header
+func decodeImage(r io.Reader, checks []ImageCheck) (image.Image, error) {
+	if len(checks) > 0 {
+		// Keep what the header takes, to decode it again
+		header := &bytes.Buffer{}
+		imCfg, format, err := image.DecodeConfig(io.TeeReader(r, header))
+		if err != nil {
+			return nil, err
+		}
+		for _, check := range checks {
+			if err := check(imCfg, format); err != nil {
+				return nil, err
+			}
+		}
+		r = io.MultiReader(header, r)
+	}
+	im, _, err := image.Decode(r)
+	return im, err
+}
+
+// PrintGoImage prints an image.Image, at the same position PrintImage would
+// (see "fbink.h"). SubImages are honored.
+// As eInk panels are grayscale, the image is converted to the most compact
+// raw layout FBInk accepts: Y8, or Y8A8 if it has any transparency (and
+// cfg.IgnoreAlpha isn't set). Gray, Gray16, NRGBA, RGBA, Paletted and
+// YCbCr images (eg: as decoded by image/png & image/jpeg) are converted
+// without going through the generic color.Color path.
+// With cfg.GoScale set, the image is scaled in Go first (and FBInk's own
+// scaling is skipped). Then, with cfg.GoDither set, its gray levels are
+// dithered, while its alpha channel (if any) is kept as is.
+func (f *FBInk) PrintGoImage(xOff, yOff int16, im image.Image, cfg *FBInkConfig) error {
+	data, w, h, rawCfg, err := f.goImageData(im, cfg)
+	if err != nil {
+		return err
+	}
+	return f.batch(func(b Backend) error {
+		return b.PrintRawData(data, w, h, xOff, yOff, rawCfg)
+	})
+}
+
+// goImageData converts (and scales, and dithers) im to the raw data
+// PrintGoImage prints, along with the config to print it with
+func (f *FBInk) goImageData(im image.Image, cfg *FBInkConfig) (data []byte, w, h int, rawCfg *FBInkConfig, err error) {
+	data, w, h = grayData(im, cfg.IgnoreAlpha)
+	if len(data) == 0 {
+		return nil, 0, 0, nil, createError("fbink_print_raw_data", eInval)
+	}
+	rawCfg = cfg
+	if cfg.GoScale != ScaleNone {
+		tw, th := int(cfg.ScaledWidth), int(cfg.ScaledHeight)
+		if tw <= 0 || th <= 0 {
+			state := FBInkState{}
+			f.do(func() { f.backend.State(cfg, &state) })
+			if tw <= 0 {
+				tw = int(state.ViewWidth)
+			}
+			if th <= 0 {
+				th = int(state.ViewHeight)
+			}
+		}
+		if tw > 0 && th > 0 {
+			data, w, h = scaleData(data, w, h, tw, th, cfg.GoScale, cfg.GoFilter, cfg.Halign, cfg.Valign)
+		}
+		// FBInk mustn't scale it again
+		scaled := *cfg
+		scaled.ScaledWidth, scaled.ScaledHeight = 0, 0
+		rawCfg = &scaled
+	}
+	if cfg.GoDither != nil {
+		data = ditherData(cfg.GoDither, data, w, h)
+	}
+	return data, w, h, rawCfg, nil
+}
+
+// ditherData dithers the gray levels of Y8 or Y8A8 data. The alpha channel
+// is carried over as is, so that FBInk still blends the image.
+func ditherData(d Ditherer, data []byte, w, h int) []byte {
+	rect := image.Rect(0, 0, w, h)
+	if len(data) == w*h {
+		dithered, _, _ := grayData(d.Dither(&image.Gray{Pix: data, Stride: w, Rect: rect}), true)
+		return dithered
+	}
+	gray := image.NewGray(rect)
+	for i := range gray.Pix {
+		gray.Pix[i] = data[i*2]
+	}
+	dithered, _, _ := grayData(d.Dither(gray), true)
+	ya := make([]byte, len(data))
+	for i, y := range dithered {
+		ya[i*2], ya[i*2+1] = y, data[i*2+1]
+	}
+	return ya
+}
+
+// luma returns the luminance of an (8-bit, non alpha-premultiplied) color,
+// with the same weights as color.GrayModel
+func luma(r, g, b uint32) byte {
+	return byte((19595*r + 38470*g + 7471*b + 1<<15) >> 16)
+}
+
+// grayData converts im to packed Y8 or Y8A8 scanlines
+func grayData(im image.Image, ignoreAlpha bool) (data []byte, w, h int) {
+	b := im.Bounds()
+	w, h = b.Dx(), b.Dy()
+	if w <= 0 || h <= 0 {
+		return nil, 0, 0
+	}
+	switch src := im.(type) {
+	case *image.Gray:
+		return packRows(src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, w, h), w, h
+	case *image.YCbCr:
+		// Y is all we need
+		return packRows(src.Y[src.YOffset(b.Min.X, b.Min.Y):], src.YStride, w, h), w, h
+	case *image.Gray16:
+		data = make([]byte, w*h)
+		for y := 0; y < h; y++ {
+			row := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				data[y*w+x] = row[x*2]
+			}
+		}
+		return data, w, h
+	}
+
+	// The rest may have an alpha channel, so start out with Y8A8
+	data = make([]byte, w*h*2)
+	opaque := true
+	switch src := im.(type) {
+	case *image.NRGBA:
+		for y := 0; y < h; y++ {
+			row := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				p := row[x*4 : x*4+4 : x*4+4]
+				i := (y*w + x) * 2
+				data[i], data[i+1] = luma(uint32(p[0]), uint32(p[1]), uint32(p[2])), p[3]
+				opaque = opaque && p[3] == 0xFF
+			}
+		}
+	case *image.RGBA:
+		for y := 0; y < h; y++ {
+			row := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				p := row[x*4 : x*4+4 : x*4+4]
+				i := (y*w + x) * 2
+				data[i], data[i+1] = unpremultiplied(p[0], p[1], p[2], p[3]), p[3]
+				opaque = opaque && p[3] == 0xFF
+			}
+		}
+	case *image.Paletted:
+		lut := make([][2]byte, len(src.Palette))
+		for i, c := range src.Palette {
+			lut[i] = grayAlpha(c)
+		}
+		for y := 0; y < h; y++ {
+			row := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				var ya [2]byte
+				if int(row[x]) < len(lut) {
+					ya = lut[row[x]]
+				}
+				i := (y*w + x) * 2
+				data[i], data[i+1] = ya[0], ya[1]
+				opaque = opaque && ya[1] == 0xFF
+			}
+		}
+	default:
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				ya := grayAlpha(im.At(b.Min.X+x, b.Min.Y+y))
+				i := (y*w + x) * 2
+				data[i], data[i+1] = ya[0], ya[1]
+				opaque = opaque && ya[1] == 0xFF
+			}
+		}
+	}
+	if !opaque && !ignoreAlpha {
+		return data, w, h
+	}
+	// Drop the alpha channel, in place
+	for i := 0; i < w*h; i++ {
+		data[i] = data[i*2]
+	}
+	return data[:w*h], w, h
+}
+
+// packRows returns the w*h bytes of a one byte per pixel image, without
+// copying them when the rows are contiguous already
+func packRows(pix []byte, stride, w, h int) []byte {
+	if stride == w {
+		return pix[:w*h]
+	}
+	data := make([]byte, w*h)
+	for y := 0; y < h; y++ {
+		copy(data[y*w:(y+1)*w], pix[y*stride:])
+	}
+	return data
+}
+
+// unpremultiplied returns the luminance of an alpha-premultiplied color
+func unpremultiplied(r, g, b, a byte) byte {
+	switch a {
+	case 0:
+		return 0
+	case 0xFF:
+		return luma(uint32(r), uint32(g), uint32(b))
+	}
+	return byte(uint32(luma(uint32(r), uint32(g), uint32(b))) * 0xFF / uint32(a))
+}
+
+func grayAlpha(c color.Color) [2]byte {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return [2]byte{luma(uint32(n.R), uint32(n.G), uint32(n.B)), n.A}
+}
@@ -0,0 +1,317 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"golang.org/x/image/bmp"
+)
+
+// threshold is a two level Ditherer
+type threshold struct{}
+
+func (threshold) Dither(im image.Image) *image.Gray {
+	b := im.Bounds()
+	gray := image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.GrayModel.Convert(im.At(x, y)).(color.Gray).Y >= 0x80 {
+				gray.Pix[gray.PixOffset(x, y)] = 0xFF
+			}
+		}
+	}
+	return gray
+}
+
+// generic hides the type of an image, to go through the color.Color path
+type generic struct{ image.Image }
+
+func TestGrayData(t *testing.T) {
+	gray := &image.Gray{Pix: []byte{0, 10, 20, 30, 40, 50}, Stride: 3, Rect: image.Rect(0, 0, 3, 2)}
+
+	gray16 := image.NewGray16(image.Rect(0, 0, 2, 1))
+	gray16.SetGray16(0, 0, color.Gray16{Y: 0x1234})
+	gray16.SetGray16(1, 0, color.Gray16{Y: 0xFFFF})
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	nrgba.SetNRGBA(0, 0, color.NRGBA{R: 0xFF, A: 0xFF})
+	nrgba.SetNRGBA(1, 0, color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF})
+	nrgba.SetNRGBA(0, 1, color.NRGBA{A: 0xFF})
+	nrgba.SetNRGBA(1, 1, color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0x80})
+
+	rgba := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	rgba.SetRGBA(0, 0, color.RGBA{R: 0x40, G: 0x40, B: 0x40, A: 0x80})
+	rgba.SetRGBA(1, 0, color.RGBA{})
+
+	paletted := image.NewPaletted(image.Rect(0, 0, 4, 1), color.Palette{color.Black, color.White, color.Transparent})
+	paletted.Pix = []byte{0, 1, 2, 3} // 3 is out of the palette
+
+	ycbcr := image.NewYCbCr(image.Rect(0, 0, 4, 2), image.YCbCrSubsampleRatio420)
+	copy(ycbcr.Y, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+
+	tests := []struct {
+		name        string
+		im          image.Image
+		ignoreAlpha bool
+		want        []byte
+	}{
+		{"Gray", gray, false, []byte{0, 10, 20, 30, 40, 50}},
+		{"Gray SubImage", gray.SubImage(image.Rect(1, 0, 3, 2)), false, []byte{10, 20, 40, 50}},
+		{"Gray16", gray16, false, []byte{0x12, 0xFF}},
+		{"NRGBA", nrgba, false, []byte{76, 0xFF, 0xFF, 0xFF, 0, 0xFF, 0xFF, 0x80}},
+		{"NRGBA IgnoreAlpha", nrgba, true, []byte{76, 0xFF, 0, 0xFF}},
+		{"NRGBA opaque SubImage", nrgba.SubImage(image.Rect(0, 0, 2, 1)), false, []byte{76, 0xFF}},
+		{"NRGBA SubImage", nrgba.SubImage(image.Rect(1, 0, 2, 2)), false, []byte{0xFF, 0xFF, 0xFF, 0x80}},
+		{"RGBA", rgba, false, []byte{0x7F, 0x80, 0, 0}},
+		{"Paletted", paletted, false, []byte{0, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0}},
+		{"Paletted SubImage", paletted.SubImage(image.Rect(0, 0, 2, 1)), false, []byte{0, 0xFF}},
+		{"YCbCr", ycbcr, false, []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{"YCbCr SubImage", ycbcr.SubImage(image.Rect(1, 1, 3, 2)), false, []byte{6, 7}},
+		{"generic", generic{nrgba}, false, []byte{76, 0xFF, 0xFF, 0xFF, 0, 0xFF, 0xFF, 0x80}},
+		{"empty", gray.SubImage(image.Rect(1, 1, 1, 2)), false, nil},
+	}
+	for _, tt := range tests {
+		got, w, h := grayData(tt.im, tt.ignoreAlpha)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: grayData = %v, want %v", tt.name, got, tt.want)
+		}
+		if b := tt.im.Bounds(); len(got) > 0 && (w != b.Dx() || h != b.Dy()) {
+			t.Errorf("%s: grayData size = %dx%d, want %dx%d", tt.name, w, h, b.Dx(), b.Dy())
+		}
+	}
+}
+
+func TestGrayDataMatchesGenericPath(t *testing.T) {
+	rect := image.Rect(-2, -1, 5, 4)
+	nrgba := image.NewNRGBA(rect)
+	rgba := image.NewRGBA(rect)
+	gray16 := image.NewGray16(rect)
+	palette := color.Palette{}
+	for i := 0; i < 16; i++ {
+		palette = append(palette, color.NRGBA{R: byte(i * 16), G: byte(i * 7), B: byte(255 - i*16), A: byte(255 - i*8)})
+	}
+	paletted := image.NewPaletted(rect, palette)
+	i := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := color.NRGBA{R: byte(i * 37), G: byte(i * 91), B: byte(i * 13), A: 0xFF}
+			nrgba.SetNRGBA(x, y, c)
+			rgba.Set(x, y, c)
+			gray16.SetGray16(x, y, color.Gray16{Y: uint16(i * 1999)})
+			paletted.SetColorIndex(x, y, byte(i%len(palette)))
+			i++
+		}
+	}
+	for _, im := range []image.Image{nrgba, rgba, gray16, paletted} {
+		sub := im.(interface {
+			SubImage(image.Rectangle) image.Image
+		}).SubImage(image.Rect(-1, 0, 3, 3))
+		for _, im := range []image.Image{im, sub} {
+			got, _, _ := grayData(im, false)
+			want, _, _ := grayData(generic{im}, false)
+			if !bytes.Equal(got, want) {
+				t.Errorf("%T %v: grayData = %v, want %v", im, im.Bounds(), got, want)
+			}
+		}
+	}
+}
+
+func TestPackRows(t *testing.T) {
+	pix := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	// Contiguous rows aren't copied
+	if got := packRows(pix, 2, 2, 3); &got[0] != &pix[0] || !bytes.Equal(got, pix[:6]) {
+		t.Errorf("packRows (contiguous) = %v, want %v, uncopied", got, pix[:6])
+	}
+	if got, want := packRows(pix, 4, 3, 2), []byte{1, 2, 3, 5, 6, 7}; !bytes.Equal(got, want) {
+		t.Errorf("packRows (strided) = %v, want %v", got, want)
+	}
+	// The last row of a SubImage ends before the stride does
+	if got, want := packRows(pix, 3, 2, 3), []byte{1, 2, 4, 5, 7, 8}; !bytes.Equal(got, want) {
+		t.Errorf("packRows (short last row) = %v, want %v", got, want)
+	}
+}
+
+func TestDitherDataKeepsAlpha(t *testing.T) {
+	y8 := []byte{0x10, 0x90, 0x7F, 0xFF}
+	if got, want := ditherData(threshold{}, y8, 2, 2), []byte{0, 0xFF, 0, 0xFF}; !bytes.Equal(got, want) {
+		t.Errorf("ditherData (Y8) = %v, want %v", got, want)
+	}
+	if !bytes.Equal(y8, []byte{0x10, 0x90, 0x7F, 0xFF}) {
+		t.Errorf("ditherData modified its input: %v", y8)
+	}
+	y8a8 := []byte{0x10, 0x80, 0x90, 0x00, 0x7F, 0xFF, 0xFF, 0x40}
+	if got, want := ditherData(threshold{}, y8a8, 2, 2), []byte{0, 0x80, 0xFF, 0x00, 0, 0xFF, 0xFF, 0x40}; !bytes.Equal(got, want) {
+		t.Errorf("ditherData (Y8A8) = %v, want %v", got, want)
+	}
+}
+
+func TestPrintGoImageDitherKeepsAlpha(t *testing.T) {
+	f, v, cfg := newTestSession(t)
+	im := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	im.SetNRGBA(0, 0, color.NRGBA{R: 0x20, G: 0x20, B: 0x20, A: 0xFF})
+	im.SetNRGBA(1, 0, color.NRGBA{A: 0}) // Transparent black
+	cfg.GoDither = threshold{}
+	data, w, h, _, err := f.goImageData(im, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0, 0xFF, 0, 0}; w != 2 || h != 1 || !bytes.Equal(data, want) {
+		t.Errorf("goImageData = %v (%dx%d), want %v (2x1)", data, w, h, want)
+	}
+	if err := f.PrintGoImage(0, 0, im, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	// The transparent pixel leaves the white screen alone
+	checkPixels(t, v.Image(), map[image.Point]uint8{{0, 0}: 0, {1, 0}: 0xFF})
+}
+
+// countingReader counts the bytes read, and hides any other method of r
+// (eg: bytes.Reader's ReadByte & Seek)
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// noise returns a w x h Gray image which doesn't compress
+func noise(w, h int) *image.Gray {
+	im := image.NewGray(image.Rect(0, 0, w, h))
+	seed := uint32(1)
+	for i := range im.Pix {
+		seed = seed*1664525 + 1013904223
+		im.Pix[i] = byte(seed >> 24)
+	}
+	return im
+}
+
+func TestDecodeImageCheckAborts(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, noise(256, 256)); err != nil {
+		t.Fatal(err)
+	}
+	errTooLarge := errors.New("too large")
+	var gotCfg image.Config
+	var gotFormat string
+	r := &countingReader{r: bytes.NewReader(buf.Bytes())}
+	_, err := decodeImage(r, []ImageCheck{func(cfg image.Config, format string) error {
+		gotCfg, gotFormat = cfg, format
+		if cfg.Width*cfg.Height > 128*128 {
+			return errTooLarge
+		}
+		return nil
+	}})
+	if err != errTooLarge {
+		t.Fatalf("decodeImage error = %v, want %v", err, errTooLarge)
+	}
+	if gotCfg.Width != 256 || gotCfg.Height != 256 || gotFormat != "png" {
+		t.Errorf("check got %dx%d %q, want 256x256 \"png\"", gotCfg.Width, gotCfg.Height, gotFormat)
+	}
+	// Only the header (and what was buffered along with it) was read
+	if r.n >= buf.Len()/2 {
+		t.Errorf("decodeImage read %d bytes out of %d before the check failed", r.n, buf.Len())
+	}
+}
+
+func TestDecodeImageReplaysHeader(t *testing.T) {
+	src := noise(64, 48)
+	pngBuf, bmpBuf := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := png.Encode(pngBuf, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := bmp.Encode(bmpBuf, src); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		format string
+		data   []byte
+	}{{"png", pngBuf.Bytes()}, {"bmp", bmpBuf.Bytes()}} {
+		for _, checks := range [][]ImageCheck{nil, {
+			func(image.Config, string) error { return nil },
+			func(cfg image.Config, format string) error {
+				if format != tt.format || cfg.Width != 64 || cfg.Height != 48 {
+					t.Errorf("%s: check got %dx%d %q", tt.format, cfg.Width, cfg.Height, format)
+				}
+				return nil
+			},
+		}} {
+			im, err := decodeImage(&countingReader{r: bytes.NewReader(tt.data)}, checks)
+			if err != nil {
+				t.Fatalf("%s (%d checks): %v", tt.format, len(checks), err)
+			}
+			got, w, h := grayData(im, false)
+			if w != 64 || h != 48 || !bytes.Equal(got, src.Pix) {
+				t.Errorf("%s (%d checks): decoded image differs from the original", tt.format, len(checks))
+			}
+		}
+	}
+}
+
+func TestPrintImageBytes(t *testing.T) {
+	f, v, cfg := newTestSession(t)
+	im := image.NewGray(image.Rect(0, 0, 2, 2))
+	im.Pix = []byte{0, 0xFF, 0x80, 0x40}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, im); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.PrintImageBytes(buf.Bytes(), 0, 0, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	checkPixels(t, v.Image(), map[image.Point]uint8{{0, 0}: 0, {1, 0}: 0xFF, {0, 1}: 0x80, {1, 1}: 0x40})
+	if err := f.PrintImageBytes([]byte("not an image"), 0, 0, &cfg); err != image.ErrFormat {
+		t.Errorf("PrintImageBytes error = %v, want %v", err, image.ErrFormat)
+	}
+}
+
+func TestPrintImageFS(t *testing.T) {
+	f, v, cfg := newTestSession(t)
+	im := image.NewGray(image.Rect(0, 0, 1, 2))
+	im.Pix = []byte{0x20, 0xA0}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, im); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{"img/dot.png": {Data: buf.Bytes()}}
+	if err := f.PrintImageFS(fsys, "img/dot.png", 0, 0, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	checkPixels(t, v.Image(), map[image.Point]uint8{{0, 0}: 0x20, {0, 1}: 0xA0})
+	if err := f.PrintImageFS(fsys, "missing.png", 0, 0, &cfg); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("PrintImageFS error = %v, want %v", err, fs.ErrNotExist)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	// Same formats as fbink_print_image
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+
+	_ "golang.org/x/image/bmp"
+)
+
+// Layout helpers shared by the pure Go backends, which follow libfbink's
+// positioning rules with its default 8x8 IBM font.
+
+// ibmGlyphSize is the size of the (unscaled) glyphs of the IBM font
+const ibmGlyphSize = 8
+
+// ibmFontMult returns the font scaling multiplier FBInk picks by default
+// for the IBM font on a screen of the given DPI
+func ibmFontMult(dpi uint16) int {
+	switch {
+	case dpi >= 300:
+		return 4
+	case dpi >= 212:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// cellGrid is the text grid of a screen
+type cellGrid struct {
+	cell     int // Size of a (square) cell, in pixels
+	cols     int
+	rows     int
+	centered bool
+}
+
+func newCellGrid(screen image.Rectangle, fontMult int, centered bool) cellGrid {
+	if fontMult < 1 {
+		fontMult = 1
+	}
+	cell := ibmGlyphSize * fontMult
+	return cellGrid{cell: cell, cols: screen.Dx() / cell, rows: screen.Dy() / cell, centered: centered}
+}
+
+// origin returns the top left cell of a grid print, FBInk style:
+// negative rows & columns count backwards from the bottom/right edges
+func (g cellGrid) origin(cfg *FBInkConfig) (col, row int) {
+	col, row = int(cfg.Col), int(cfg.Row)
+	if col < 0 {
+		col += g.cols
+	}
+	if row < 0 {
+		row += g.rows
+	}
+	if cfg.IsHalfway {
+		row += g.rows / 2
+	}
+	if col < 0 {
+		col = 0
+	} else if col >= g.cols {
+		col = g.cols - 1
+	}
+	if row < 0 {
+		row = 0
+	} else if row >= g.rows {
+		row = g.rows - 1
+	}
+	return col, row
+}
+
+// rect returns the pixel area covered by cols x rows cells
+func (g cellGrid) rect(cols, rows int, cfg *FBInkConfig) image.Rectangle {
+	col, row := g.origin(cfg)
+	if g.centered {
+		col = (g.cols - cols) / 2
+	}
+	x := col*g.cell + int(cfg.Hoffset)
+	y := row*g.cell + int(cfg.Voffset)
+	return image.Rect(x, y, x+cols*g.cell, y+rows*g.cell)
+}
+
+// imageOrigin positions an image of the given size on screen, FBInk style
+func (g cellGrid) imageOrigin(screen image.Rectangle, w, h int, x, y int16, cfg *FBInkConfig) image.Point {
+	p := image.Pt(int(x)+int(cfg.Col)*g.cell, int(y)+int(cfg.Row)*g.cell)
+	switch cfg.Halign {
+	case Center:
+		p.X += (screen.Dx() - w) / 2
+	case Edge:
+		p.X += screen.Dx() - w
+	}
+	switch cfg.Valign {
+	case Center:
+		p.Y += (screen.Dy() - h) / 2
+	case Edge:
+		p.Y += screen.Dy() - h
+	}
+	return p
+}
+
+// decodeImageFile decodes an image file with the registered Go decoders
+func decodeImageFile(path string) (image.Image, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, createError("fbink_print_image", exitFailure)
+	}
+	defer fh.Close()
+	img, _, err := image.Decode(fh)
+	if err != nil {
+		return nil, createError("fbink_print_image", exitFailure)
+	}
+	return img, nil
+}
+
+// rawImage wraps packed Y, YA, RGB or RGBA scanlines in an image.Image,
+// the pixel format being deduced from the size of data
+func rawImage(data []byte, w, h int) (image.Image, error) {
+	if w <= 0 || h <= 0 || len(data) == 0 || len(data)%(w*h) != 0 {
+		return nil, createError("fbink_print_raw_data", eInval)
+	}
+	rect := image.Rect(0, 0, w, h)
+	switch len(data) / (w * h) {
+	case 1:
+		return &image.Gray{Pix: data, Stride: w, Rect: rect}, nil
+	case 2:
+		// YA has no stdlib equivalent, so expand it
+		nrgba := image.NewNRGBA(rect)
+		for i := 0; i < w*h; i++ {
+			nrgba.Pix[i*4] = data[i*2]
+			nrgba.Pix[i*4+1] = data[i*2]
+			nrgba.Pix[i*4+2] = data[i*2]
+			nrgba.Pix[i*4+3] = data[i*2+1]
+		}
+		return nrgba, nil
+	case 3:
+		nrgba := image.NewNRGBA(rect)
+		for i := 0; i < w*h; i++ {
+			copy(nrgba.Pix[i*4:], data[i*3:i*3+3])
+			nrgba.Pix[i*4+3] = 0xFF
+		}
+		return nrgba, nil
+	case 4:
+		return &image.NRGBA{Pix: data, Stride: w * 4, Rect: rect}, nil
+	}
+	return nil, createError("fbink_print_raw_data", eInval)
+}
+
+// invertImage returns a copy of src with inverted colors (but not alpha)
+func invertImage(src image.Image) image.Image {
+	b := src.Bounds()
+	inv := image.NewNRGBA(b)
+	draw.Draw(inv, b, src, b.Min, draw.Src)
+	for i := 0; i < len(inv.Pix); i += 4 {
+		inv.Pix[i] ^= 0xFF
+		inv.Pix[i+1] ^= 0xFF
+		inv.Pix[i+2] ^= 0xFF
+	}
+	return inv
+}
+
+// compositeOp returns the draw.Op honoring IgnoreAlpha
+func compositeOp(cfg *FBInkConfig) draw.Op {
+	if cfg.IgnoreAlpha {
+		return draw.Src
+	}
+	return draw.Over
+}
+
+// uniform is a shorthand for a uniform gray image
+func uniform(c uint8) *image.Uniform {
+	return image.NewUniform(color.Gray{c})
+}
@@ -0,0 +1,509 @@
+//go:build cgo && !nolibfbink
+// +build cgo,!nolibfbink
+
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+// #cgo LDFLAGS: -L${SRCDIR}/../fbinklib -lfbink -lm
+// #include <stdlib.h>
+// #include <errno.h>
+// #include "fbink.h"
+import "C"
+import (
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// libFBInk is the Backend driving the real thing, via libfbink
+type libFBInk struct {
+	// mu guards fbfd, which is only ever written by Open & Close (on the
+	// render goroutine), but also read by the waits, which bypass it
+	mu   sync.Mutex
+	fbfd C.int
+}
+
+// newDefaultBackend returns the Backend used by New
+func newDefaultBackend() Backend {
+	return &libFBInk{fbfd: C.FBFD_AUTO}
+}
+
+// lib returns the libfbink backend of the session. Functions that only
+// exist in libfbink fail with ErrNotImplemented on other backends.
+func (f *FBInk) lib(op string) (*libFBInk, error) {
+	if l, ok := f.backend.(*libFBInk); ok {
+		return l, nil
+	}
+	return nil, createError(op, eNoSys)
+}
+
+func newConfigC(cfg *FBInkConfig) C.FBInkConfig {
+	var cfgC C.FBInkConfig
+	cfgC.row = C.short(cfg.Row)
+	cfgC.col = C.short(cfg.Col)
+	cfgC.fontmult = C.uint8_t(cfg.fontmult)
+	cfgC.fontname = C.uint8_t(cfg.fontname)
+	cfgC.is_inverted = C.bool(cfg.IsInverted)
+	cfgC.is_flashing = C.bool(cfg.IsFlashing)
+	cfgC.is_cleared = C.bool(cfg.IsCleared)
+	cfgC.is_centered = C.bool(cfg.isCentered)
+	cfgC.hoffset = C.short(cfg.Hoffset)
+	cfgC.voffset = C.short(cfg.Voffset)
+	cfgC.is_halfway = C.bool(cfg.IsHalfway)
+	cfgC.is_padded = C.bool(cfg.IsPadded)
+	cfgC.is_rpadded = C.bool(cfg.IsRpadded)
+	cfgC.fg_color = C.uint8_t(cfg.fgColor)
+	cfgC.bg_color = C.uint8_t(cfg.bgColor)
+	cfgC.is_overlay = C.bool(cfg.IsOverlay)
+	cfgC.is_bgless = C.bool(cfg.IsBGless)
+	cfgC.is_fgless = C.bool(cfg.isFGless)
+	cfgC.no_viewport = C.bool(cfg.noViewport)
+	cfgC.is_verbose = C.bool(cfg.isVerbose)
+	cfgC.is_quiet = C.bool(cfg.isQuiet)
+	cfgC.ignore_alpha = C.bool(cfg.IgnoreAlpha)
+	cfgC.halign = C.uint8_t(cfg.Halign)
+	cfgC.valign = C.uint8_t(cfg.Valign)
+	cfgC.scaled_width = C.short(cfg.ScaledWidth)
+	cfgC.scaled_height = C.short(cfg.ScaledHeight)
+	cfgC.wfm_mode = C.uint8_t(cfg.WfmMode)
+	cfgC.dithering_mode = C.uint8_t(cfg.DitheringMode)
+	cfgC.sw_dithering = C.bool(cfg.SWDithering)
+	cfgC.is_nightmode = C.bool(cfg.IsNightmode)
+	cfgC.no_refresh = C.bool(cfg.NoRefresh)
+	cfgC.to_syslog = C.bool(cfg.toSyslog)
+	return cfgC
+}
+
+func newOTConfigC(otCfg *FBInkOTConfig) C.FBInkOTConfig {
+	var otCfgC C.FBInkOTConfig
+	// Opaque pointer, managed by OTFontSet via the _v2 ot_font API.
+	// A nil pointer means we use the global font pool.
+	otCfgC.font = nil
+	if otCfg.Fonts != nil {
+		otCfgC.font = otCfg.Fonts.font()
+	}
+	otCfgC.margins.top = C.short(otCfg.Margins.Top)
+	otCfgC.margins.bottom = C.short(otCfg.Margins.Bottom)
+	otCfgC.margins.left = C.short(otCfg.Margins.Left)
+	otCfgC.margins.right = C.short(otCfg.Margins.Right)
+	otCfgC.style = C.int(otCfg.Style)
+	otCfgC.size_pt = C.float(otCfg.SizePt)
+	otCfgC.size_px = C.uint16_t(otCfg.SizePx)
+	otCfgC.is_centered = C.bool(otCfg.IsCentred)
+	otCfgC.padding = C.uint8_t(otCfg.Padding)
+	otCfgC.is_formatted = C.bool(otCfg.IsFormatted)
+	otCfgC.compute_only = C.bool(otCfg.ComputeOnly)
+	otCfgC.no_truncation = C.bool(otCfg.NoTruncation)
+	return otCfgC
+}
+
+func rectFromC(rectC C.FBInkRect) FBInkRect {
+	return FBInkRect{
+		Left:   uint16(rectC.left),
+		Top:    uint16(rectC.top),
+		Width:  uint16(rectC.width),
+		Height: uint16(rectC.height),
+	}
+}
+
+func rectToC(rect *FBInkRect) C.FBInkRect {
+	var rectC C.FBInkRect
+	rectC.left = C.uint16_t(rect.Left)
+	rectC.top = C.uint16_t(rect.Top)
+	rectC.width = C.uint16_t(rect.Width)
+	rectC.height = C.uint16_t(rect.Height)
+	return rectC
+}
+
+func (l *libFBInk) Open() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Only open if we haven't already obtained a file descriptor
+	if l.fbfd == C.FBFD_AUTO {
+		l.fbfd = C.fbink_open()
+		if l.fbfd < 0 {
+			l.fbfd = C.FBFD_AUTO
+			return createError("fbink_open", exitFailure)
+		}
+	}
+	return nil
+}
+
+func (l *libFBInk) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Nothing to do unless we obtained a file descriptor!
+	if l.fbfd == C.FBFD_AUTO {
+		return nil
+	}
+	res := CexitCode(C.fbink_close(l.fbfd))
+	l.fbfd = C.FBFD_AUTO
+	return createError("fbink_close", res)
+}
+
+func (l *libFBInk) Init(cfg *FBInkConfig) error {
+	cfgC := newConfigC(cfg)
+	res := CexitCode(C.fbink_init(l.fbfd, &cfgC))
+	return createError("fbink_init", res)
+}
+
+func (l *libFBInk) ReInit(cfg *FBInkConfig) (ReinitChange, error) {
+	cfgC := newConfigC(cfg)
+	res := CexitCode(C.fbink_reinit(l.fbfd, &cfgC))
+	if err := createError("fbink_reinit", res); err != nil {
+		return 0, err
+	}
+	return ReinitChange(res), nil
+}
+
+func (l *libFBInk) State(cfg *FBInkConfig, state *FBInkState) {
+	cfgC := newConfigC(cfg)
+	stateC := C.FBInkState{}
+	C.fbink_get_state(&cfgC, &stateC)
+	state.UserHZ = int(stateC.user_hz)
+	state.FontName = C.GoString(stateC.font_name)
+	state.ViewWidth = uint32(stateC.view_width)
+	state.ViewHeight = uint32(stateC.view_height)
+	state.ScreenWidth = uint32(stateC.screen_width)
+	state.ScreenHeight = uint32(stateC.screen_height)
+	state.BPP = uint32(stateC.bpp)
+	state.DeviceName = C.GoString(&stateC.device_name[0])
+	state.DeviceCodename = C.GoString(&stateC.device_codename[0])
+	state.DevicePlatform = C.GoString(&stateC.device_platform[0])
+	state.DeviceID = uint16(stateC.device_id)
+	state.PenFGcolor = uint8(stateC.pen_fg_color)
+	state.PenBGcolor = uint8(stateC.pen_bg_color)
+	state.ScreenDPI = uint16(stateC.screen_dpi)
+	state.FontW = uint16(stateC.font_w)
+	state.FontH = uint16(stateC.font_h)
+	state.MaxCols = uint16(stateC.max_cols)
+	state.MaxRows = uint16(stateC.max_rows)
+	state.ViewHoriOrigin = uint8(stateC.view_hori_origin)
+	state.ViewVertOrigin = uint8(stateC.view_vert_origin)
+	state.ViewVertOffset = uint8(stateC.view_vert_offset)
+	state.FontSizeMult = uint8(stateC.fontsize_mult)
+	state.GlyphWidth = uint8(stateC.glyph_width)
+	state.GlyphHeight = uint8(stateC.glyph_height)
+	state.IsPerfectFit = bool(stateC.is_perfect_fit)
+	state.IsPBSunxi = bool(stateC.is_pb_sunxi)
+	state.IsKindleLegacy = bool(stateC.is_kindle_legacy)
+	state.IsKoboNonMT = bool(stateC.is_kobo_non_mt)
+	state.NTXBootRota = uint8(stateC.ntx_boot_rota)
+	state.NTXRotaQuirk = NTXRota(stateC.ntx_rota_quirk)
+	state.IsNTXQuirkyLandscape = bool(stateC.is_ntx_quirky_landscape)
+	state.CurrentRota = uint8(stateC.current_rota)
+	state.CanRotate = bool(stateC.can_rotate)
+	state.CanHWInvert = bool(stateC.can_hw_invert)
+}
+
+func (l *libFBInk) Print(str string, cfg *FBInkConfig) (int, error) {
+	cfgC := newConfigC(cfg)
+	strC := C.CString(str)
+	defer C.free(unsafe.Pointer(strC))
+	rows := int(C.fbink_print(l.fbfd, strC, &cfgC))
+	return rows, createError("fbink_print", CexitCode(rows))
+}
+
+func (l *libFBInk) PrintOT(str string, otCfg *FBInkOTConfig, cfg *FBInkConfig) (int, FBInkOTFit, error) {
+	cfgC := newConfigC(cfg)
+	otCfgC := newOTConfigC(otCfg)
+	strC := C.CString(str)
+	defer C.free(unsafe.Pointer(strC))
+	var fitC C.FBInkOTFit
+	res := C.fbink_print_ot(l.fbfd, strC, &otCfgC, &cfgC, &fitC)
+	runtime.KeepAlive(otCfg.Fonts)
+	fit := FBInkOTFit{
+		ComputedLines: uint16(fitC.computed_lines),
+		RenderedLines: uint16(fitC.rendered_lines),
+		Truncated:     bool(fitC.truncated),
+	}
+	return int(res), fit, createError("fbink_print_ot", CexitCode(res))
+}
+
+func (l *libFBInk) PrintImage(path string, x, y int16, cfg *FBInkConfig) error {
+	cfgC := newConfigC(cfg)
+	pathC := C.CString(path)
+	defer C.free(unsafe.Pointer(pathC))
+	res := CexitCode(C.fbink_print_image(l.fbfd, pathC, C.short(x), C.short(y), &cfgC))
+	return createError("fbink_print_image", res)
+}
+
+func (l *libFBInk) PrintRawData(data []byte, w, h int, x, y int16, cfg *FBInkConfig) error {
+	if len(data) == 0 {
+		return createError("fbink_print_raw_data", eInval)
+	}
+	cfgC := newConfigC(cfg)
+	res := CexitCode(C.fbink_print_raw_data(
+		l.fbfd,
+		(*C.uchar)(unsafe.Pointer(&data[0])),
+		C.int(w),
+		C.int(h),
+		C.size_t(len(data)),
+		C.short(x),
+		C.short(y),
+		&cfgC))
+	return createError("fbink_print_raw_data", res)
+}
+
+func (l *libFBInk) ClearScreen(cfg *FBInkConfig, rect *FBInkRect) error {
+	cfgC := newConfigC(cfg)
+	var rectC C.FBInkRect
+	if rect != nil {
+		rectC = rectToC(rect)
+	}
+	res := CexitCode(C.fbink_cls(l.fbfd, &cfgC, &rectC))
+	return createError("fbink_cls", res)
+}
+
+func (l *libFBInk) GridClear(cols, rows uint16, cfg *FBInkConfig) error {
+	cfgC := newConfigC(cfg)
+	res := CexitCode(C.fbink_grid_clear(l.fbfd, C.ushort(cols), C.ushort(rows), &cfgC))
+	return createError("fbink_grid_clear", res)
+}
+
+func (l *libFBInk) Refresh(top, left, width, height uint32, cfg *FBInkConfig) error {
+	cfgC := newConfigC(cfg)
+	topC := C.uint32_t(top)
+	leftC := C.uint32_t(left)
+	widthC := C.uint32_t(width)
+	heightC := C.uint32_t(height)
+	res := CexitCode(C.fbink_refresh(l.fbfd, topC, leftC, widthC, heightC, &cfgC))
+	return createError("fbink_refresh", res)
+}
+
+func (l *libFBInk) GridRefresh(cols, rows uint16, cfg *FBInkConfig) error {
+	cfgC := newConfigC(cfg)
+	res := CexitCode(C.fbink_grid_refresh(l.fbfd, C.ushort(cols), C.ushort(rows), &cfgC))
+	return createError("fbink_grid_refresh", res)
+}
+
+// fd returns the current file descriptor, for the calls which don't go
+// through the render queue
+func (l *libFBInk) fd() C.int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fbfd
+}
+
+func (l *libFBInk) WaitForSubmission(marker uint32) error {
+	// Don't hold the lock while the kernel waits for the panel
+	res := CexitCode(C.fbink_wait_for_submission(l.fd(), C.uint32_t(marker)))
+	return createError("fbink_wait_for_submission", res)
+}
+
+func (l *libFBInk) WaitForCompletion(marker uint32) error {
+	res := CexitCode(C.fbink_wait_for_complete(l.fd(), C.uint32_t(marker)))
+	return createError("fbink_wait_for_complete", res)
+}
+
+func (l *libFBInk) LastMarker() uint32 {
+	return uint32(C.fbink_get_last_marker())
+}
+
+func (l *libFBInk) LastRect() FBInkRect {
+	return rectFromC(C.fbink_get_last_rect())
+}
+
+// cDumpData is dump data allocated by libfbink
+type cDumpData struct {
+	data *C.uchar
+	size C.size_t
+}
+
+func (c *cDumpData) bytes() []byte {
+	return (*[1 << 30]byte)(unsafe.Pointer(c.data))[:c.size:c.size]
+}
+
+func (c *cDumpData) free() error {
+	var dumpC C.FBInkDump
+	dumpC.data = c.data
+	res := CexitCode(C.fbink_free_dump_data(&dumpC))
+	c.data = nil
+	return createError("fbink_free_dump_data", res)
+}
+
+// dumpFromC wraps a freshly filled C dump into a Go owned FBInkDump
+func dumpFromC(dumpC *C.FBInkDump) *FBInkDump {
+	d := &FBInkDump{
+		Stride: uint(dumpC.stride),
+		Size:   uint(dumpC.size),
+		Area:   rectFromC(dumpC.area),
+		Clip:   rectFromC(dumpC.clip),
+		Rota:   uint8(dumpC.rota),
+		BPP:    uint8(dumpC.bpp),
+		IsFull: bool(dumpC.is_full),
+	}
+	d.setData(&cDumpData{data: dumpC.data, size: dumpC.size})
+	return d
+}
+
+func (l *libFBInk) Dump() (*FBInkDump, error) {
+	var dumpC C.FBInkDump
+	res := CexitCode(C.fbink_dump(l.fbfd, &dumpC))
+	if err := createError("fbink_dump", res); err != nil {
+		return nil, err
+	}
+	return dumpFromC(&dumpC), nil
+}
+
+func (l *libFBInk) RegionDump(x, y int16, w, h uint16, cfg *FBInkConfig) (*FBInkDump, error) {
+	cfgC := newConfigC(cfg)
+	var dumpC C.FBInkDump
+	res := CexitCode(C.fbink_region_dump(
+		l.fbfd,
+		C.short(x),
+		C.short(y),
+		C.ushort(w),
+		C.ushort(h),
+		&cfgC,
+		&dumpC))
+	if err := createError("fbink_region_dump", res); err != nil {
+		return nil, err
+	}
+	return dumpFromC(&dumpC), nil
+}
+
+func (l *libFBInk) Restore(dump *FBInkDump, cfg *FBInkConfig) error {
+	cfgC := newConfigC(cfg)
+	// Rebuild the C representation of the dump, including any changes
+	// the caller made to Clip and IsFull
+	var dumpC C.FBInkDump
+	if data, ok := dump.data.(*cDumpData); ok {
+		dumpC.data = data.data
+	}
+	dumpC.stride = C.size_t(dump.Stride)
+	dumpC.size = C.size_t(dump.Size)
+	dumpC.area = rectToC(&dump.Area)
+	dumpC.clip = rectToC(&dump.Clip)
+	dumpC.rota = C.uint8_t(dump.Rota)
+	dumpC.bpp = C.uint8_t(dump.BPP)
+	dumpC.is_full = C.bool(dump.IsFull)
+	res := CexitCode(C.fbink_restore(l.fbfd, &cfgC, &dumpC))
+	runtime.KeepAlive(dump)
+	return createError("fbink_restore", res)
+}
+
+// Version gets the fbink version
+func (f *FBInk) Version() string {
+	vers := C.GoString(C.fbink_version())
+	return vers
+}
+
+// AddOTfont registers an OpenType or TrueType font with FBInk
+// At least one font needs to be specified to use the OT print function
+// See "fbink.h" for detailed usage and explanation
+func (f *FBInk) AddOTfont(filename string, fntStyle FontStyle) error {
+	fnC := C.CString(filename)
+	defer C.free(unsafe.Pointer(fnC))
+	var res CexitCode
+	f.do(func() { res = CexitCode(C.fbink_add_ot_font(fnC, C.FONT_STYLE_T(fntStyle))) })
+	return createError("fbink_add_ot_font", res)
+}
+
+// FreeOTfonts frees any loaded OT font. This MUST be called at the
+// conclusion of OT printing, to avoid memory leaks
+func (f *FBInk) FreeOTfonts() error {
+	var res CexitCode
+	f.do(func() { res = CexitCode(C.fbink_free_ot_fonts()) })
+	return createError("fbink_free_ot_fonts", res)
+}
+
+// PrintProgressBar displays a full width progress bar
+// NOTE: percentage should be a number between 0 - 100
+// See "fbink.h" for detailed usage and explanation
+func (f *FBInk) PrintProgressBar(percentage uint8, cfg *FBInkConfig) error {
+	l, err := f.lib("fbink_print_progress_bar")
+	if err != nil {
+		return err
+	}
+	cfgC := newConfigC(cfg)
+	percentC := C.uint8_t(percentage)
+	var res CexitCode
+	f.do(func() { res = CexitCode(C.fbink_print_progress_bar(l.fbfd, percentC, &cfgC)) })
+	return createError("fbink_print_progress_bar", res)
+}
+
+// PrintActivityBar displays a full width activity bar
+// NOTE: progress should be a number between 0 - 19.
+//
+//	where 0 enables an infinite activity bar!
+//
+// See "fbink.h" for detailed usage and explanation
+func (f *FBInk) PrintActivityBar(progress uint8, cfg *FBInkConfig) error {
+	l, err := f.lib("fbink_print_activity_bar")
+	if err != nil {
+		return err
+	}
+	cfgC := newConfigC(cfg)
+	progressC := C.uint8_t(progress)
+	var res CexitCode
+	f.do(func() { res = CexitCode(C.fbink_print_activity_bar(l.fbfd, progressC, &cfgC)) })
+	return createError("fbink_print_activity_bar", res)
+}
+
+// RotaNativeToCanonical attempts to convert a native vInfo rotate constant to a canonical representation
+// See "fbink.h" for detailed usage and explanation
+func (f *FBInk) RotaNativeToCanonical(rotate uint32) uint8 {
+	res := C.fbink_rota_native_to_canonical(C.uint32_t(rotate))
+	return uint8(res)
+}
+
+// RotaCanonicalToNative attempts to convert a canonical representation to a native vInfo rotate constant
+// See "fbink.h" for detailed usage and explanation
+func (f *FBInk) RotaCanonicalToNative(rotate uint8) uint32 {
+	res := C.fbink_rota_canonical_to_native(C.uint8_t(rotate))
+	return uint32(res)
+}
+
+// TODO: fbink_update_verbosity, fbink_update_pen_colors
+//       (which don't make much sense given the RestrictedConfig concept here ;)).
+
+// ButtonScan will scan for the 'Connect' button on the Kobo USB connect screen
+// See "fbink.h" for detailed usage and explanation
+func (f *FBInk) ButtonScan(pressButton, noSleep bool) error {
+	l, err := f.lib("fbink_button_scan")
+	if err != nil {
+		return err
+	}
+	pressBtnC := C.bool(pressButton)
+	noSleepC := C.bool(noSleep)
+	var res CexitCode
+	f.do(func() { res = CexitCode(C.fbink_button_scan(l.fbfd, pressBtnC, noSleepC)) })
+	return createError("fbink_button_scan", res)
+}
+
+// WaitForUSBMSprocessing waits for the end of a kobo USBMS session
+// It also tries to detect a succesful content import
+// See "fbink.h" for detailed usage and explanation
+func (f *FBInk) WaitForUSBMSprocessing(forceUnplug bool) error {
+	l, err := f.lib("fbink_wait_for_usbms_processing")
+	if err != nil {
+		return err
+	}
+	forceUnplugC := C.bool(forceUnplug)
+	var res CexitCode
+	f.do(func() { res = CexitCode(C.fbink_wait_for_usbms_processing(l.fbfd, forceUnplugC)) })
+	return createError("fbink_wait_for_usbms_processing", res)
+}
@@ -0,0 +1,285 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// DefaultLogInterval is the default minimum time between two refreshes of
+// a console logged to
+const DefaultLogInterval = 500 * time.Millisecond
+
+// LogHandlerOptions configures a LogHandler
+type LogHandlerOptions struct {
+	// Level is the minimum level logged, slog.LevelInfo if nil
+	Level slog.Leveler
+	// Interval is the minimum time between two refreshes. Records logged in
+	// the meantime are batched, and drawn at once. 0 uses DefaultLogInterval.
+	Interval time.Duration
+}
+
+// LogHandler is a slog.Handler printing records to a Console, one line per
+// record. Errors are inverted, warnings are bold (when the session's font
+// has a bold variant, eg: Terminus, and on a gray background otherwise),
+// and debug records are gray (which requires libfbink).
+// Records are drawn asynchronously, and rate limited: a burst of records
+// only costs a single refresh. Flush must be called once done logging, as
+// records logged less than an interval ago may not have been drawn yet.
+type LogHandler struct {
+	b      *logBatcher
+	level  slog.Leveler
+	attrs  string // Preformatted attributes from WithAttrs
+	prefix string // Group prefix of the attributes to come
+}
+
+// NewLogHandler creates a LogHandler printing to c. opts may be nil.
+func NewLogHandler(c *Console, opts *LogHandlerOptions) *LogHandler {
+	if opts == nil {
+		opts = &LogHandlerOptions{}
+	}
+	h := &LogHandler{b: newLogBatcher(c, opts.Interval), level: opts.Level}
+	if h.level == nil {
+		h.level = slog.LevelInfo
+	}
+	return h
+}
+
+// Enabled implements slog.Handler
+func (h *LogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle implements slog.Handler. It only queues the record, so it returns
+// the error of the last drawing, if any.
+func (h *LogHandler) Handle(_ context.Context, r slog.Record) error {
+	buf := &bytes.Buffer{}
+	if !r.Time.IsZero() {
+		buf.WriteString(r.Time.Format("15:04:05 "))
+	}
+	buf.WriteString(r.Level.String())
+	buf.WriteByte(' ')
+	buf.WriteString(r.Message)
+	buf.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(buf, h.prefix, a)
+		return true
+	})
+	style := lineStyle{}
+	switch {
+	case r.Level >= slog.LevelError:
+		style.inverted = true
+	case r.Level >= slog.LevelWarn:
+		style.bold = true
+	case r.Level < slog.LevelInfo:
+		style.dim = true
+	}
+	return h.b.add(consoleLine{text: buf.String(), style: style})
+}
+
+// WithAttrs implements slog.Handler
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	buf := &bytes.Buffer{}
+	for _, a := range attrs {
+		appendAttr(buf, h.prefix, a)
+	}
+	h2.attrs += buf.String()
+	return &h2
+}
+
+// WithGroup implements slog.Handler
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix += name + "."
+	return &h2
+}
+
+// Flush draws the pending records right away, and returns the error of the
+// last drawing, if any
+func (h *LogHandler) Flush() error {
+	return h.b.flush()
+}
+
+// appendAttr formats a as " key=value", in the manner of slog.TextHandler
+func appendAttr(buf *bytes.Buffer, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(buf, prefix, ga)
+		}
+		return
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(quoteIfNeeded(prefix + a.Key))
+	buf.WriteByte('=')
+	buf.WriteString(quoteIfNeeded(a.Value.String()))
+}
+
+func quoteIfNeeded(s string) string {
+	if s == "" || strings.IndexFunc(s, func(r rune) bool {
+		return r == '=' || r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r)
+	}) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// NewLogWriter returns a LogWriter printing to c, one line per line
+// written, for use with the standard log package:
+//
+//	w := gofbink.NewLogWriter(c, 0)
+//	defer w.Close()
+//	log.SetOutput(w)
+//
+// Like LogHandler, lines are drawn asynchronously, at most once per
+// interval (0 uses DefaultLogInterval).
+func NewLogWriter(c *Console, interval time.Duration) *LogWriter {
+	return &LogWriter{b: newLogBatcher(c, interval)}
+}
+
+// LogWriter is an io.Writer printing lines to a Console. See NewLogWriter.
+// Close (or at least Flush) must be called once done logging, as lines
+// written less than an interval ago may not have been drawn yet.
+type LogWriter struct {
+	b       *logBatcher
+	mu      sync.Mutex
+	partial []byte // Unterminated line
+}
+
+// Write queues the lines in p. An unterminated line is held back until
+// the rest of it is written, or until Close. It returns the error of the
+// last drawing, if any.
+func (w *LogWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSuffix(string(w.partial[:i]), "\r")
+		w.partial = w.partial[i+1:]
+		err = w.b.add(consoleLine{text: line})
+	}
+	return len(p), err
+}
+
+// Flush draws the pending lines right away, and returns the error of the
+// last drawing, if any
+func (w *LogWriter) Flush() error {
+	return w.b.flush()
+}
+
+// Close draws the pending lines, including an unterminated one, and
+// returns the error of the last drawing, if any
+func (w *LogWriter) Close() error {
+	w.mu.Lock()
+	if len(w.partial) > 0 {
+		w.b.add(consoleLine{text: strings.TrimSuffix(string(w.partial), "\r")})
+		w.partial = nil
+	}
+	w.mu.Unlock()
+	return w.b.flush()
+}
+
+// logBatcher rate limits the drawing of lines to a console
+type logBatcher struct {
+	c        *Console
+	interval time.Duration
+	mu       sync.Mutex
+	pending  []consoleLine
+	timer    *time.Timer // Pending flush, if any
+	last     time.Time   // Of the last flush
+	err      error       // Of the last flush
+	flushMu  sync.Mutex  // Keeps flushes in order
+}
+
+func newLogBatcher(c *Console, interval time.Duration) *logBatcher {
+	if interval <= 0 {
+		interval = DefaultLogInterval
+	}
+	return &logBatcher{c: c, interval: interval}
+}
+
+// add queues a line, and schedules a flush if there isn't one pending yet.
+// The first line after a quiet period is drawn right away.
+func (b *logBatcher) add(line consoleLine) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(b.pending, line)
+	// Lines that would scroll off before being drawn aren't worth keeping
+	if keep := b.c.cc.MaxLines + b.c.cc.Scrollback; b.c.cc.MaxLines > 0 && len(b.pending) > keep {
+		b.pending = append(b.pending[:0], b.pending[len(b.pending)-keep:]...)
+	}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(time.Until(b.last.Add(b.interval)), func() { b.flush() })
+	}
+	return b.err
+}
+
+// flush draws the pending lines at once
+func (b *logBatcher) flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	lines := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+	var err error
+	if len(lines) > 0 {
+		b.c.f.do(func() { err = b.c.println(lines...) })
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.last = time.Now()
+	if len(lines) > 0 {
+		b.err = err
+	}
+	return b.err
+}
@@ -0,0 +1,83 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogWriter(t *testing.T) {
+	c := newTestConsole(t, 0)
+	w := NewLogWriter(c, time.Hour)
+	// The first line is drawn right away, the next ones wait for the interval
+	if _, err := w.Write([]byte("one\ntwo\r\nthr")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("ee\nfour")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := consoleView(c), []string{"one", "two", "three", "four"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("view = %q, want %q", got, want)
+	}
+}
+
+func TestLogHandler(t *testing.T) {
+	c := newTestConsole(t, 20)
+	h := NewLogHandler(c, &LogHandlerOptions{Level: slog.LevelDebug, Interval: time.Hour})
+	log := slog.New(h).With("id", 1).WithGroup("g")
+	log.Debug("d")
+	log.Warn("w", "k", "v w")
+	log.Error("e")
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var lines []consoleLine
+	c.f.do(func() { lines = append(lines, c.lines...) })
+	want := []struct {
+		text  string
+		style lineStyle
+	}{
+		{"DEBUG d id=1", lineStyle{dim: true}},
+		{"WARN w id=1 g.k=\"v w\"", lineStyle{bold: true}},
+		{"ERROR e id=1", lineStyle{inverted: true}},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("%d lines, want %d", len(lines), len(want))
+	}
+	for i, l := range lines {
+		// Skip the timestamp
+		if text := l.text[len("15:04:05 "):]; text != want[i].text || l.style != want[i].style {
+			t.Errorf("line %d = %q %+v, want %q %+v", i, text, l.style, want[i].text, want[i].style)
+		}
+	}
+}
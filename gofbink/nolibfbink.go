@@ -0,0 +1,196 @@
+//go:build !cgo || nolibfbink
+// +build !cgo nolibfbink
+
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"image/color"
+)
+
+// Without libfbink (i.e., without cgo, or with the nolibfbink build tag),
+// sessions need a pure Go Backend (see NewWithBackend), and everything that
+// only libfbink provides fails with ErrNotImplemented.
+
+// noLibFBInk is the default Backend when libfbink isn't available
+type noLibFBInk struct{}
+
+// newDefaultBackend returns the Backend used by New
+func newDefaultBackend() Backend {
+	return noLibFBInk{}
+}
+
+func (noLibFBInk) Open() error                     { return createError("fbink_open", eNoSys) }
+func (noLibFBInk) Close() error                    { return nil }
+func (noLibFBInk) Init(cfg *FBInkConfig) error     { return createError("fbink_init", eNoSys) }
+func (noLibFBInk) State(*FBInkConfig, *FBInkState) {}
+func (noLibFBInk) LastMarker() uint32              { return LastMarker }
+func (noLibFBInk) LastRect() FBInkRect             { return FBInkRect{} }
+
+func (noLibFBInk) ReInit(cfg *FBInkConfig) (ReinitChange, error) {
+	return 0, createError("fbink_reinit", eNoSys)
+}
+
+func (noLibFBInk) Print(str string, cfg *FBInkConfig) (int, error) {
+	return int(eNoSys), createError("fbink_print", eNoSys)
+}
+
+func (noLibFBInk) PrintOT(str string, otCfg *FBInkOTConfig, cfg *FBInkConfig) (int, FBInkOTFit, error) {
+	return int(eNoSys), FBInkOTFit{}, createError("fbink_print_ot", eNoSys)
+}
+
+func (noLibFBInk) PrintImage(path string, x, y int16, cfg *FBInkConfig) error {
+	return createError("fbink_print_image", eNoSys)
+}
+
+func (noLibFBInk) PrintRawData(data []byte, w, h int, x, y int16, cfg *FBInkConfig) error {
+	return createError("fbink_print_raw_data", eNoSys)
+}
+
+func (noLibFBInk) ClearScreen(cfg *FBInkConfig, rect *FBInkRect) error {
+	return createError("fbink_cls", eNoSys)
+}
+
+func (noLibFBInk) GridClear(cols, rows uint16, cfg *FBInkConfig) error {
+	return createError("fbink_grid_clear", eNoSys)
+}
+
+func (noLibFBInk) Refresh(top, left, width, height uint32, cfg *FBInkConfig) error {
+	return createError("fbink_refresh", eNoSys)
+}
+
+func (noLibFBInk) GridRefresh(cols, rows uint16, cfg *FBInkConfig) error {
+	return createError("fbink_grid_refresh", eNoSys)
+}
+
+func (noLibFBInk) WaitForSubmission(marker uint32) error {
+	return createError("fbink_wait_for_submission", eNoSys)
+}
+
+func (noLibFBInk) WaitForCompletion(marker uint32) error {
+	return createError("fbink_wait_for_complete", eNoSys)
+}
+
+func (noLibFBInk) Dump() (*FBInkDump, error) {
+	return nil, createError("fbink_dump", eNoSys)
+}
+
+func (noLibFBInk) RegionDump(x, y int16, w, h uint16, cfg *FBInkConfig) (*FBInkDump, error) {
+	return nil, createError("fbink_region_dump", eNoSys)
+}
+
+func (noLibFBInk) Restore(dump *FBInkDump, cfg *FBInkConfig) error {
+	return createError("fbink_restore", eNoSys)
+}
+
+// Version gets the fbink version
+func (f *FBInk) Version() string {
+	return ""
+}
+
+// AddOTfont registers an OpenType or TrueType font with FBInk
+func (f *FBInk) AddOTfont(filename string, fntStyle FontStyle) error {
+	return createError("fbink_add_ot_font", eNoSys)
+}
+
+// FreeOTfonts frees any loaded OT font
+func (f *FBInk) FreeOTfonts() error {
+	return nil
+}
+
+// PrintProgressBar displays a full width progress bar
+func (f *FBInk) PrintProgressBar(percentage uint8, cfg *FBInkConfig) error {
+	return createError("fbink_print_progress_bar", eNoSys)
+}
+
+// PrintActivityBar displays a full width activity bar
+func (f *FBInk) PrintActivityBar(progress uint8, cfg *FBInkConfig) error {
+	return createError("fbink_print_activity_bar", eNoSys)
+}
+
+// RotaNativeToCanonical attempts to convert a native vInfo rotate constant to a canonical representation
+// Without libfbink, there are no device quirks to account for
+func (f *FBInk) RotaNativeToCanonical(rotate uint32) uint8 {
+	return uint8(rotate)
+}
+
+// RotaCanonicalToNative attempts to convert a canonical representation to a native vInfo rotate constant
+// Without libfbink, there are no device quirks to account for
+func (f *FBInk) RotaCanonicalToNative(rotate uint8) uint32 {
+	return uint32(rotate)
+}
+
+// ButtonScan will scan for the 'Connect' button on the Kobo USB connect screen
+func (f *FBInk) ButtonScan(pressButton, noSleep bool) error {
+	return createError("fbink_button_scan", eNoSys)
+}
+
+// WaitForUSBMSprocessing waits for the end of a kobo USBMS session
+func (f *FBInk) WaitForUSBMSprocessing(forceUnplug bool) error {
+	return createError("fbink_wait_for_usbms_processing", eNoSys)
+}
+
+// SetFGPen sets the foreground pen color directly
+func (f *FBInk) SetFGPen(c color.Color, quantize bool) error {
+	return createError("fbink_set_fg_pen", eNoSys)
+}
+
+// SetBGPen sets the background pen color directly
+func (f *FBInk) SetBGPen(c color.Color, quantize bool) error {
+	return createError("fbink_set_bg_pen", eNoSys)
+}
+
+// UpdateFGPen sets the foreground pen color, unless it already is c
+func (f *FBInk) UpdateFGPen(c color.Color, quantize bool) (changed bool, err error) {
+	return false, createError("fbink_set_fg_pen", eNoSys)
+}
+
+// UpdateBGPen sets the background pen color, unless it already is c
+func (f *FBInk) UpdateBGPen(c color.Color, quantize bool) (changed bool, err error) {
+	return false, createError("fbink_set_bg_pen", eNoSys)
+}
+
+func (f *FBInk) setPens(fg, bg color.Color) error {
+	return createError("fbink_set_fg_pen", eNoSys)
+}
+
+// OTFontSet is a set of OpenType or TrueType fonts private to the
+// FBInkOTConfigs using it
+type OTFontSet struct{}
+
+// NewOTFontSet creates an empty font set
+func NewOTFontSet() *OTFontSet {
+	return &OTFontSet{}
+}
+
+// AddFont loads a font file for the given style into the set
+func (s *OTFontSet) AddFont(filename string, fntStyle FontStyle) error {
+	return createError("fbink_add_ot_font_v2", eNoSys)
+}
+
+// Free releases every font in the set
+func (s *OTFontSet) Free() error {
+	return nil
+}
@@ -0,0 +1,289 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"errors"
+	"fmt"
+)
+
+// OTConsoleConfig defines the box an OTConsole prints to, and its font
+type OTConsoleConfig struct {
+	// OTcfg sets the font, size & style of the lines (fonts have to be
+	// loaded beforehand, see AddOTfont). Its margins define the
+	// console's box, in pixels.
+	OTcfg    FBInkOTConfig
+	MaxLines int // Amount of (latest) lines shown. 0 shows as many as fit
+}
+
+type otLine struct {
+	text        string
+	top, height int // Where it was drawn, in pixels
+}
+
+// OTConsole is a Console laying out its lines with PrintOT, in any OpenType
+// font, inside a box. Lines longer than the box is wide wrap, and the line
+// height follows the font size. Once the box is full, the older lines are
+// scrolled off.
+type OTConsole struct {
+	f            *FBInk
+	oc           OTConsoleConfig
+	lines        []otLine // Lines on screen
+	lineH        int      // Measured line height, 0 until known
+	viewW, viewH uint32   // What the lines were laid out for
+	viewX, viewY uint8    // Origin of the viewport on screen
+}
+
+// NewOTConsole creates a console printing to the box defined by oc
+func (f *FBInk) NewOTConsole(oc OTConsoleConfig) *OTConsole {
+	return &OTConsole{f: f, oc: oc}
+}
+
+// Println prints a new line to the console, scrolling if needed
+func (c *OTConsole) Println(a ...interface{}) (n int, err error) {
+	str := fmt.Sprint(a...)
+	n = len([]byte(str))
+	c.f.do(func() {
+		if c.relayout() || (c.oc.MaxLines > 0 && len(c.lines) >= c.oc.MaxLines) {
+			err = c.scroll(str)
+			return
+		}
+		top, bottom := c.box()
+		y := top
+		if len(c.lines) > 0 {
+			last := c.lines[len(c.lines)-1]
+			y = last.top + last.height
+		}
+		if y+c.lineH > bottom {
+			err = c.scroll(str)
+			return
+		}
+		line, printErr := c.print(str, y, len(c.lines) > 0)
+		if errors.Is(printErr, ErrNoSpace) || errors.Is(printErr, ErrRange) && len(c.lines) > 0 {
+			err = c.scroll(str)
+			return
+		}
+		if printErr != nil {
+			err = printErr
+			return
+		}
+		c.lines = append(c.lines, line)
+		err = c.refresh(line.top, line.height)
+	})
+	return n, err
+}
+
+// PrintLastLn replaces the last line of the console, scrolling if the new
+// one is taller and doesn't fit
+func (c *OTConsole) PrintLastLn(a ...interface{}) (n int, err error) {
+	str := fmt.Sprint(a...)
+	n = len([]byte(str))
+	c.f.do(func() {
+		if c.relayout() || len(c.lines) == 0 {
+			if len(c.lines) > 0 {
+				c.lines = c.lines[:len(c.lines)-1]
+			}
+			err = c.scroll(str)
+			return
+		}
+		last := c.lines[len(c.lines)-1]
+		if err = c.clear(last.top, last.height); err != nil {
+			return
+		}
+		line, printErr := c.print(str, last.top, len(c.lines) > 1)
+		if errors.Is(printErr, ErrNoSpace) {
+			c.lines = c.lines[:len(c.lines)-1]
+			err = c.scroll(str)
+			return
+		}
+		if printErr != nil {
+			err = printErr
+			return
+		}
+		c.lines[len(c.lines)-1] = line
+		err = c.refresh(line.top, maxInt(line.height, last.height))
+	})
+	return n, err
+}
+
+// Clear forgets the console's lines, and blanks its box
+func (c *OTConsole) Clear() (err error) {
+	c.f.do(func() {
+		c.relayout()
+		c.lines = nil
+		top, bottom := c.box()
+		if err = c.clear(top, bottom-top); err == nil {
+			err = c.refresh(top, bottom-top)
+		}
+	})
+	return err
+}
+
+// box returns the vertical extent of the console's box, in pixels.
+// It runs on the render goroutine (as do all the following methods).
+func (c *OTConsole) box() (top, bottom int) {
+	return int(c.oc.OTcfg.Margins.Top), int(c.viewH) - int(c.oc.OTcfg.Margins.Bottom)
+}
+
+// relayout checks whether the viewport changed (eg: after a rotation), in
+// which case the lines have to be laid out again, and reports it
+func (c *OTConsole) relayout() bool {
+	state := FBInkState{}
+	c.f.backend.State(&c.f.internCfg, &state)
+	// ViewVertOrigin includes the row balancing offset, which PrintOT's
+	// margins don't
+	c.viewX, c.viewY = state.ViewHoriOrigin, state.ViewVertOrigin-state.ViewVertOffset
+	if state.ViewWidth == c.viewW && state.ViewHeight == c.viewH {
+		return false
+	}
+	c.viewW, c.viewH = state.ViewWidth, state.ViewHeight
+	c.lineH = 0
+	return len(c.lines) > 0
+}
+
+// print draws str at y, without refreshing it. With noTruncation, it fails
+// with ErrNoSpace when str doesn't fit in the rest of the box.
+func (c *OTConsole) print(str string, y int, noTruncation bool) (otLine, error) {
+	str = orSpace(str)
+	_, bottom := c.box()
+	otCfg := c.oc.OTcfg
+	otCfg.Margins.Top = int16(y)
+	otCfg.Padding = Horizontal
+	otCfg.ComputeOnly = false
+	otCfg.NoTruncation = noTruncation
+	cfg := c.f.internCfg
+	cfg.NoRefresh = true
+	next, fit, err := c.f.backend.PrintOT(str, &otCfg, &cfg)
+	if err != nil {
+		return otLine{}, err
+	}
+	line := otLine{text: str, top: y}
+	rendered := int(fit.RenderedLines)
+	switch {
+	case next > y && rendered > 0:
+		line.height = next - y
+		c.lineH = line.height / rendered
+	case c.lineH > 0:
+		// No room left below, so the height can't be measured
+		line.height = minInt(rendered*c.lineH, bottom-y)
+	default:
+		line.height = bottom - y
+	}
+	return line, nil
+}
+
+// scroll redraws the box with str, and as many of the latest lines as fit
+// above it
+func (c *OTConsole) scroll(str string) error {
+	top, bottom := c.box()
+	// Find out how tall the new line is going to be
+	height := bottom - top
+	if c.lineH > 0 {
+		otCfg := c.oc.OTcfg
+		otCfg.ComputeOnly = true
+		cfg := c.f.internCfg
+		cfg.NoRefresh = true
+		if _, fit, err := c.f.backend.PrintOT(orSpace(str), &otCfg, &cfg); err == nil && fit.ComputedLines > 0 {
+			height = int(fit.ComputedLines) * c.lineH
+		}
+	}
+	keep := 0
+	for i := len(c.lines) - 1; i >= 0; i-- {
+		if c.oc.MaxLines > 0 && keep+2 > c.oc.MaxLines {
+			break
+		}
+		height += c.lines[i].height
+		if height > bottom-top {
+			break
+		}
+		keep++
+	}
+	texts := make([]string, 0, keep+1)
+	for _, line := range c.lines[len(c.lines)-keep:] {
+		texts = append(texts, line.text)
+	}
+	texts = append(texts, str)
+
+	if err := c.clear(top, bottom-top); err != nil {
+		return err
+	}
+	c.lines = c.lines[:0]
+	y := top
+	for i, text := range texts {
+		line, err := c.print(text, y, false)
+		if err != nil && i == len(texts)-1 && len(c.lines) > 0 {
+			// Our estimates were off, make room
+			c.lines = nil
+			if err = c.clear(top, bottom-top); err == nil {
+				line, err = c.print(text, top, false)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		c.lines = append(c.lines, line)
+		y = line.top + line.height
+	}
+	return c.refresh(top, bottom-top)
+}
+
+// clear blanks part of the box
+func (c *OTConsole) clear(y, height int) error {
+	if height <= 0 {
+		return nil
+	}
+	cfg := c.f.internCfg
+	cfg.NoRefresh = true
+	return c.f.backend.ClearScreen(&cfg, c.rect(y, height))
+}
+
+// refresh refreshes part of the box
+func (c *OTConsole) refresh(y, height int) error {
+	if height <= 0 {
+		return nil
+	}
+	r := c.rect(y, height)
+	return c.f.backend.Refresh(uint32(r.Top), uint32(r.Left), uint32(r.Width), uint32(r.Height), &c.f.internCfg)
+}
+
+// rect returns part of the box, in screen coordinates (y being relative to
+// the viewport, like the margins)
+func (c *OTConsole) rect(y, height int) *FBInkRect {
+	left := int(c.oc.OTcfg.Margins.Left)
+	width := int(c.viewW) - left - int(c.oc.OTcfg.Margins.Right)
+	return &FBInkRect{
+		Left:   uint16(int(c.viewX) + left),
+		Top:    uint16(int(c.viewY) + y),
+		Width:  uint16(width),
+		Height: uint16(height),
+	}
+}
+
+// orSpace makes empty lines still take up a line
+func orSpace(str string) string {
+	if str == "" {
+		return " "
+	}
+	return str
+}
@@ -0,0 +1,87 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"testing"
+)
+
+// originFB is a VirtualFB whose viewport is offset on screen, like on
+// devices with hidden rows or columns of pixels. y is the origin of row 0,
+// which includes the row balancing offset, as in libfbink.
+type originFB struct {
+	*VirtualFB
+	x, y, offset uint8
+}
+
+func (o originFB) State(cfg *FBInkConfig, state *FBInkState) {
+	o.VirtualFB.State(cfg, state)
+	state.ViewHoriOrigin, state.ViewVertOrigin, state.ViewVertOffset = o.x, o.y, o.offset
+}
+
+// Print positions text relative to the origin, as libfbink does
+func (o originFB) Print(str string, cfg *FBInkConfig) (int, error) {
+	shifted := *cfg
+	shifted.Hoffset += int16(o.x)
+	shifted.Voffset += int16(o.y)
+	return o.VirtualFB.Print(str, &shifted)
+}
+
+// PrintRawData positions images relative to the origin, as libfbink does
+func (o originFB) PrintRawData(data []byte, w, h int, x, y int16, cfg *FBInkConfig) error {
+	return o.VirtualFB.PrintRawData(data, w, h, x+int16(o.x), y+int16(o.y), cfg)
+}
+
+func TestOTConsoleViewportOrigin(t *testing.T) {
+	// The viewport starts at (3, 7) on screen, minus the row offset
+	for _, offset := range []uint8{0, 4} {
+		v := NewVirtualFB(160, 240, 150, 0)
+		cfg := FBInkConfig{}
+		f := NewWithBackend(originFB{v, 3, 7, offset}, &cfg, &RestrictedConfig{})
+		defer f.Close()
+		oc := OTConsoleConfig{}
+		oc.OTcfg.SizePx = 16 // 20px lines
+		oc.OTcfg.Margins.Top, oc.OTcfg.Margins.Bottom = 10, 10
+		oc.OTcfg.Margins.Left, oc.OTcfg.Margins.Right = 5, 5
+		c := f.NewOTConsole(oc)
+		v.ResetRefreshes()
+		if _, err := c.Println("hi"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Println("there"); err != nil {
+			t.Fatal(err)
+		}
+		// PrintOT refreshes nothing, the console refreshes its lines
+		top := 17 - int(offset)
+		checkRefreshes(t, v, fbRect(8, top, 150, 20), fbRect(8, top+20, 150, 20))
+		v.ResetRefreshes()
+		if err := c.Clear(); err != nil {
+			t.Fatal(err)
+		}
+		checkRefreshes(t, v, fbRect(8, top, 150, 220))
+		if got, want := v.LastRect(), fbRect(8, top, 150, 220); got != want {
+			t.Errorf("offset %d: cleared %v, want %v", offset, got, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import "errors"
+
+// fitStep is the granularity of the FitOT font size search, in points
+const fitStep = 0.5
+
+// marginsForRect sets the OT margins so that the printable area is rect
+func (f *FBInk) marginsForRect(otCfg *FBInkOTConfig, rect FBInkRect, cfg *FBInkConfig) {
+	state := FBInkState{}
+	f.GetState(cfg, &state)
+	otCfg.Margins.Top = int16(rect.Top)
+	otCfg.Margins.Left = int16(rect.Left)
+	otCfg.Margins.Bottom = int16(int(state.ViewHeight) - int(rect.Top) - int(rect.Height))
+	otCfg.Margins.Right = int16(int(state.ViewWidth) - int(rect.Left) - int(rect.Width))
+}
+
+// FitOT looks for the largest font size between minPt and maxPt (in steps
+// of half a point) at which text fits inside rect in at most maxLines lines
+// (0 means as many as fit). Fonts, style & formatting are taken from otCfg,
+// while margins and sizes are overridden. It returns the size in points,
+// and the fit information at that size.
+// If text doesn't fit even at minPt, it returns an ErrNoSpace error.
+// The search itself is done with cheap ComputeOnly passes. The winning size
+// is then checked with a real NoTruncation pass with NoRefresh set, which
+// draws to the framebuffer without refreshing the screen: print the text
+// in the same rect afterwards to actually show it.
+func (f *FBInk) FitOT(text string, rect FBInkRect, minPt, maxPt float32, maxLines uint16, otCfg *FBInkOTConfig, cfg *FBInkConfig) (float32, FBInkOTFit, error) {
+	trialOT := *otCfg
+	f.marginsForRect(&trialOT, rect, cfg)
+	trialOT.SizePx = 0
+	trialOT.NoTruncation = true
+	trialCfg := *cfg
+	trialCfg.NoRefresh = true
+
+	// fits reports whether text fits at the given size
+	fits := func(size float32, computeOnly bool) (bool, FBInkOTFit, error) {
+		trialOT.SizePt = size
+		trialOT.ComputeOnly = computeOnly
+		_, fit, err := f.PrintOT(text, &trialOT, &trialCfg)
+		if errors.Is(err, ErrNoSpace) {
+			return false, fit, nil
+		} else if err != nil {
+			return false, fit, err
+		}
+		if fit.Truncated || (maxLines > 0 && fit.ComputedLines > maxLines) {
+			return false, fit, nil
+		}
+		return true, fit, nil
+	}
+
+	// Binary search over the steps between minPt & maxPt
+	lo, hi := 0, int((maxPt-minPt)/fitStep)
+	best := -1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		ok, _, err := fits(minPt+float32(mid)*fitStep, true)
+		if err != nil {
+			return 0, FBInkOTFit{}, err
+		}
+		if ok {
+			best = mid
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+	// Broken metrics may still lead to a late truncation, so step down
+	// until an actual rendering pass agrees with the computations
+	for ; best >= 0; best-- {
+		size := minPt + float32(best)*fitStep
+		ok, fit, err := fits(size, false)
+		if err != nil {
+			return 0, FBInkOTFit{}, err
+		}
+		if ok {
+			return size, fit, nil
+		}
+	}
+	return 0, FBInkOTFit{}, createError("fbink_print_ot", eNoSpc)
+}
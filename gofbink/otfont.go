@@ -0,0 +1,100 @@
+//go:build cgo && !nolibfbink
+// +build cgo,!nolibfbink
+
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+// #include <stdlib.h>
+// #include "fbink.h"
+import "C"
+import (
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// OTFontSet is a set of OpenType or TrueType fonts (one per FontStyle)
+// that is private to the FBInkOTConfigs using it, instead of living in
+// the global pool managed by AddOTfont & FreeOTfonts.
+// Set FBInkOTConfig.Fonts to use it. The fonts are released by Free,
+// or when the set is garbage collected.
+type OTFontSet struct {
+	mu sync.Mutex
+	// FBInk keeps track of the fonts through the opaque font pointer of
+	// an FBInkOTConfig. That one lives in C memory, so it stays put.
+	cfgC *C.FBInkOTConfig
+}
+
+// NewOTFontSet creates an empty font set
+func NewOTFontSet() *OTFontSet {
+	s := &OTFontSet{}
+	s.cfgC = (*C.FBInkOTConfig)(C.calloc(1, C.size_t(unsafe.Sizeof(C.FBInkOTConfig{}))))
+	runtime.SetFinalizer(s, (*OTFontSet).Free)
+	return s
+}
+
+// AddFont loads a font file for the given style into the set
+// See "fbink.h" for detailed usage and explanation
+func (s *OTFontSet) AddFont(filename string, fntStyle FontStyle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cfgC == nil {
+		return createError("fbink_add_ot_font_v2", eInval)
+	}
+	fnC := C.CString(filename)
+	defer C.free(unsafe.Pointer(fnC))
+	res := CexitCode(C.fbink_add_ot_font_v2(fnC, C.FONT_STYLE_T(fntStyle), s.cfgC))
+	return createError("fbink_add_ot_font_v2", res)
+}
+
+// Free releases every font in the set. The set cannot be used afterwards,
+// so don't call it while a PrintOT using the set may still be running.
+// It is safe to call Free more than once.
+func (s *OTFontSet) Free() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cfgC == nil {
+		return nil
+	}
+	var res CexitCode
+	// FBInk complains if there's nothing to free, which we don't care about
+	if s.cfgC.font != nil {
+		res = CexitCode(C.fbink_free_ot_fonts_v2(s.cfgC))
+	}
+	C.free(unsafe.Pointer(s.cfgC))
+	s.cfgC = nil
+	runtime.SetFinalizer(s, nil)
+	return createError("fbink_free_ot_fonts_v2", res)
+}
+
+// font returns the opaque font pointer to stick in an FBInkOTConfig
+func (s *OTFontSet) font() unsafe.Pointer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cfgC == nil {
+		return nil
+	}
+	return s.cfgC.font
+}
@@ -0,0 +1,56 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"image/color"
+)
+
+// Palette is the 16 level grayscale eInk palette, from black to white
+var Palette = color.Palette{
+	color.Gray{0x00}, color.Gray{0x11}, color.Gray{0x22}, color.Gray{0x33},
+	color.Gray{0x44}, color.Gray{0x55}, color.Gray{0x66}, color.Gray{0x77},
+	color.Gray{0x88}, color.Gray{0x99}, color.Gray{0xAA}, color.Gray{0xBB},
+	color.Gray{0xCC}, color.Gray{0xDD}, color.Gray{0xEE}, color.Gray{0xFF},
+}
+
+// Gray returns the palette color matching a foreground color index
+func (c FGcolor) Gray() color.Gray {
+	return Palette[c&0x0F].(color.Gray)
+}
+
+// Gray returns the palette color matching a background color index
+func (c BGcolor) Gray() color.Gray {
+	return Palette[0x0F-c&0x0F].(color.Gray)
+}
+
+// FGcolorFrom returns the foreground color index closest to c
+func FGcolorFrom(c color.Color) FGcolor {
+	return FGcolor(Palette.Index(c))
+}
+
+// BGcolorFrom returns the background color index closest to c
+func BGcolorFrom(c color.Color) BGcolor {
+	return BGcolor(0x0F - Palette.Index(c))
+}
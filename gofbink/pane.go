@@ -0,0 +1,163 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"image"
+)
+
+// PaneConfig defines a Pane
+type PaneConfig struct {
+	// Rect is the area of the pane, in pixels, relative to the viewport
+	// (see FBInkState.ViewWidth & ViewHeight). It's clamped to the
+	// viewport.
+	Rect       image.Rectangle
+	Fontmult   uint8   // Font scaling multiplier. 0 uses the session's
+	Border     int     // Width of the border, in pixels. 0 for none
+	MaxLines   int     // Amount of (latest) lines shown. 0 shows as many as fit
+	Scrollback int     // Amount of rows kept around, above the view, for paging back
+	FGcolor    FGcolor // Colors of the text & border, which require libfbink
+	BGcolor    BGcolor // (FGblack & BGwhite keep the session's pens)
+}
+
+// Pane is a Console printing to a rectangle of the screen, set in pixels
+// rather than text cells, with its own font size and an optional border.
+// As many rows & columns as fit in the rectangle are used, so the layout
+// holds whatever the font, and text never spills into the neighbouring
+// panes.
+type Pane struct {
+	*Console
+	pc PaneConfig
+
+	// Set up by grid, on the render goroutine
+	inner        image.Rectangle // Inside the border
+	origin       image.Point     // Of the viewport's row 0, on screen
+	cellW, cellH int
+	mult         int // Font scaling multiplier of the pane
+	sessionMult  int // And of the session, as initialized
+}
+
+// NewPane creates a pane, blank until something is printed to it (or it is
+// cleared). Panes of a session shouldn't overlap.
+func (f *FBInk) NewPane(pc PaneConfig) *Pane {
+	p := &Pane{pc: pc}
+	p.Console = f.NewConsole(ConsoleConfig{
+		MaxLines:   pc.MaxLines,
+		Scrollback: pc.Scrollback,
+		FGcolor:    pc.FGcolor,
+		BGcolor:    pc.BGcolor,
+	})
+	p.Console.pane = p
+	return p
+}
+
+// grid lays out the pane's text cells for the session's current state, and
+// returns their amount
+func (p *Pane) grid(state *FBInkState) (rows, cols int) {
+	p.sessionMult = int(state.FontSizeMult)
+	p.mult = int(p.pc.Fontmult)
+	if p.mult == 0 {
+		p.mult = p.sessionMult
+	}
+	p.cellW, p.cellH = int(state.GlyphWidth)*p.mult, int(state.GlyphHeight)*p.mult
+	p.origin = image.Pt(int(state.ViewHoriOrigin), int(state.ViewVertOrigin))
+	view := image.Rect(0, 0, int(state.ViewWidth), int(state.ViewHeight))
+	inner := p.pc.Rect.Intersect(view).Inset(p.pc.Border)
+	if inner != p.inner {
+		// Moved (eg: after a rotation), start afresh
+		p.inner = inner
+		p.Console.shown = nil
+	}
+	if p.cellW < 1 || p.cellH < 1 || p.inner.Empty() {
+		return 0, 0
+	}
+	return p.inner.Dy() / p.cellH, p.inner.Dx() / p.cellW
+}
+
+// drawCfg returns the config to draw the pane with, and whether it takes
+// reinitializing libfbink, which is only the case when the session isn't
+// already set up with the pane's multiplier
+func (p *Pane) drawCfg(cfg FBInkConfig) (FBInkConfig, bool) {
+	needsInit := cfg.isCentered || p.mult != p.sessionMult
+	if needsInit {
+		cfg.fontmult = uint8(p.mult)
+	}
+	cfg.isCentered = false
+	return cfg, needsInit
+}
+
+// place positions cfg on a row of the pane, with pixel offsets
+func (p *Pane) place(cfg *FBInkConfig, row int) {
+	cfg.Row, cfg.Col = 0, 0
+	cfg.Hoffset = int16(p.inner.Min.X)
+	cfg.Voffset = int16(p.inner.Min.Y + row*p.cellH)
+}
+
+// rowsRect returns the pixel area of some rows, on screen
+func (p *Pane) rowsRect(first, last int) image.Rectangle {
+	r := image.Rect(p.inner.Min.X, p.inner.Min.Y+first*p.cellH, p.inner.Max.X, p.inner.Min.Y+(last+1)*p.cellH)
+	return r.Add(p.origin)
+}
+
+// outer returns the pixel area of the whole pane, border included, on screen
+func (p *Pane) outer() image.Rectangle {
+	return p.inner.Inset(-p.pc.Border).Add(p.origin)
+}
+
+// frame blanks the pane, and draws its border, without refreshing it.
+// The clear takes a rect on screen, while the border is positioned like
+// any image, i.e., relative to the viewport.
+func (p *Pane) frame(b Backend, cfg *FBInkConfig, fg uint8) error {
+	screen := p.outer()
+	rect := FBInkRect{Left: uint16(screen.Min.X), Top: uint16(screen.Min.Y), Width: uint16(screen.Dx()), Height: uint16(screen.Dy())}
+	if err := b.ClearScreen(cfg, &rect); err != nil {
+		return err
+	}
+	if p.pc.Border <= 0 {
+		return nil
+	}
+	rawCfg := *cfg
+	rawCfg.Row, rawCfg.Col = 0, 0
+	rawCfg.Hoffset, rawCfg.Voffset = 0, 0
+	rawCfg.Halign, rawCfg.Valign = AlignNone, AlignNone
+	r, inner := p.inner.Inset(-p.pc.Border), p.inner
+	for _, edge := range []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, inner.Min.Y),
+		image.Rect(r.Min.X, inner.Max.Y, r.Max.X, r.Max.Y),
+		image.Rect(r.Min.X, inner.Min.Y, inner.Min.X, inner.Max.Y),
+		image.Rect(inner.Max.X, inner.Min.Y, r.Max.X, inner.Max.Y),
+	} {
+		if edge.Empty() {
+			continue
+		}
+		data := make([]byte, edge.Dx()*edge.Dy())
+		for i := range data {
+			data[i] = fg
+		}
+		if err := b.PrintRawData(data, edge.Dx(), edge.Dy(), int16(edge.Min.X), int16(edge.Min.Y), &rawCfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"image"
+	"testing"
+)
+
+// initCounter counts the (re)initializations of a backend
+type initCounter struct {
+	Backend
+	inits int
+}
+
+func (c *initCounter) Init(cfg *FBInkConfig) error {
+	c.inits++
+	return c.Backend.Init(cfg)
+}
+
+func TestPaneViewportOrigin(t *testing.T) {
+	v := NewVirtualFB(160, 240, 150, 0)
+	cfg := FBInkConfig{}
+	f := NewWithBackend(originFB{v, 3, 7, 0}, &cfg, &RestrictedConfig{})
+	defer f.Close()
+	p := f.NewPane(PaneConfig{Rect: image.Rect(10, 20, 110, 120), Border: 2})
+	v.ResetRefreshes()
+	if _, err := p.Println("a"); err != nil {
+		t.Fatal(err)
+	}
+	// The first draw blanks the whole pane, and refreshes it, border included
+	checkRefreshes(t, v, fbRect(13, 27, 100, 100))
+	// The text and the border line up, inside the refreshed area
+	checkPixels(t, v.Image(), map[image.Point]uint8{
+		{13, 27}:   0x00, // Border
+		{50, 28}:   0x00,
+		{13, 126}:  0x00,
+		{112, 126}: 0x00,
+		{112, 100}: 0x00,
+		{18, 31}:   0x00, // "a"
+		{28, 42}:   0x00,
+		{31, 30}:   0xFF, // Inside
+		{50, 29}:   0xFF,
+		{110, 124}: 0xFF,
+		{113, 100}: 0xFF, // Outside
+		{12, 50}:   0xFF,
+		{50, 26}:   0xFF,
+	})
+	v.ResetRefreshes()
+	if _, err := p.Println("b"); err != nil {
+		t.Fatal(err)
+	}
+	// Then only the rows that changed (the inner area is 96px, i.e., 6 rows
+	// of 16px, the first one at the top)
+	checkRefreshes(t, v, fbRect(15, 45, 96, 16))
+}
+
+func TestPaneFontmult(t *testing.T) {
+	tests := []struct {
+		name     string
+		fontmult uint8
+		inits    int
+	}{
+		{"session's", 0, 0},
+		{"same as the session's", 2, 0},
+		{"different", 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := &initCounter{Backend: NewVirtualFB(160, 240, 150, 0)}
+			cfg := FBInkConfig{}
+			f := NewWithBackend(counter, &cfg, &RestrictedConfig{})
+			defer f.Close()
+			p := f.NewPane(PaneConfig{Rect: image.Rect(0, 0, 160, 64), Fontmult: tt.fontmult})
+			for _, l := range []string{"a", "b"} {
+				counter.inits = 0
+				if _, err := p.Println(l); err != nil {
+					t.Fatal(err)
+				}
+				// Once for the pane, and once to restore the session's
+				if counter.inits != tt.inits {
+					t.Errorf("%d inits, want %d", counter.inits, tt.inits)
+				}
+			}
+		})
+	}
+}
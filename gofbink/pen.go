@@ -0,0 +1,146 @@
+//go:build cgo && !nolibfbink
+// +build cgo,!nolibfbink
+
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+// #include "fbink.h"
+import "C"
+import (
+	"image/color"
+)
+
+type penSetter struct {
+	grayOp string
+	rgbaOp string
+	gray   func(y C.uint8_t, quantize, update C.bool) C.int
+	rgba   func(r, g, b, a C.uint8_t, quantize, update C.bool) C.int
+}
+
+var (
+	fgPen = penSetter{
+		grayOp: "fbink_set_fg_pen_gray",
+		rgbaOp: "fbink_set_fg_pen_rgba",
+		gray:   func(y C.uint8_t, q, u C.bool) C.int { return C.fbink_set_fg_pen_gray(y, q, u) },
+		rgba:   func(r, g, b, a C.uint8_t, q, u C.bool) C.int { return C.fbink_set_fg_pen_rgba(r, g, b, a, q, u) },
+	}
+	bgPen = penSetter{
+		grayOp: "fbink_set_bg_pen_gray",
+		rgbaOp: "fbink_set_bg_pen_rgba",
+		gray:   func(y C.uint8_t, q, u C.bool) C.int { return C.fbink_set_bg_pen_gray(y, q, u) },
+		rgba:   func(r, g, b, a C.uint8_t, q, u C.bool) C.int { return C.fbink_set_bg_pen_rgba(r, g, b, a, q, u) },
+	}
+)
+
+// set sets the pen to c. Grays take the cheaper luminance only codepath.
+// It returns false if update was requested, and the pen was already c.
+func (p penSetter) set(c color.Color, quantize, update bool) (bool, error) {
+	var res CexitCode
+	var op string
+	if g, ok := c.(color.Gray); ok {
+		op = p.grayOp
+		res = CexitCode(p.gray(C.uint8_t(g.Y), C.bool(quantize), C.bool(update)))
+	} else {
+		op = p.rgbaOp
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		res = CexitCode(p.rgba(
+			C.uint8_t(n.R),
+			C.uint8_t(n.G),
+			C.uint8_t(n.B),
+			C.uint8_t(n.A),
+			C.bool(quantize),
+			C.bool(update)))
+	}
+	if err := createError(op, res); err != nil {
+		return false, err
+	}
+	return res != exitOkSameColorAlready, nil
+}
+
+// SetFGPen sets the foreground pen color directly, without going
+// through UpdateRestricted (and the full re-init it implies).
+// quantize rounds c to the nearest eInk palette color.
+// NOTE: The next Init or UpdateRestricted resets the pen to the
+// FGcolor of the RestrictedConfig.
+// See "fbink.h" for detailed usage and explanation
+func (f *FBInk) SetFGPen(c color.Color, quantize bool) error {
+	_, err := f.lib("fbink_set_fg_pen")
+	if err != nil {
+		return err
+	}
+	f.do(func() { _, err = fgPen.set(c, quantize, false) })
+	return err
+}
+
+// SetBGPen sets the background pen color directly, without going
+// through UpdateRestricted (and the full re-init it implies).
+// quantize rounds c to the nearest eInk palette color.
+// NOTE: The next Init or UpdateRestricted resets the pen to the
+// BGcolor of the RestrictedConfig.
+// See "fbink.h" for detailed usage and explanation
+func (f *FBInk) SetBGPen(c color.Color, quantize bool) error {
+	_, err := f.lib("fbink_set_bg_pen")
+	if err != nil {
+		return err
+	}
+	f.do(func() { _, err = bgPen.set(c, quantize, false) })
+	return err
+}
+
+// UpdateFGPen is SetFGPen, except that it bails out early if the pen is
+// already set to c. It reports whether the pen color actually changed.
+// Keep in mind that for non-gray colors, the comparison is done after
+// grayscaling.
+func (f *FBInk) UpdateFGPen(c color.Color, quantize bool) (changed bool, err error) {
+	if _, err = f.lib("fbink_set_fg_pen"); err != nil {
+		return false, err
+	}
+	f.do(func() { changed, err = fgPen.set(c, quantize, true) })
+	return changed, err
+}
+
+// UpdateBGPen is SetBGPen, except that it bails out early if the pen is
+// already set to c. It reports whether the pen color actually changed.
+// Keep in mind that for non-gray colors, the comparison is done after
+// grayscaling.
+func (f *FBInk) UpdateBGPen(c color.Color, quantize bool) (changed bool, err error) {
+	if _, err = f.lib("fbink_set_bg_pen"); err != nil {
+		return false, err
+	}
+	f.do(func() { changed, err = bgPen.set(c, quantize, true) })
+	return changed, err
+}
+
+// setPens sets both pens at once, from the render goroutine
+func (f *FBInk) setPens(fg, bg color.Color) error {
+	if _, err := f.lib("fbink_set_fg_pen"); err != nil {
+		return err
+	}
+	if _, err := fgPen.set(fg, false, true); err != nil {
+		return err
+	}
+	_, err := bgPen.set(bg, false, true)
+	return err
+}
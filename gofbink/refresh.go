@@ -0,0 +1,163 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"context"
+	"image"
+	"sync"
+)
+
+// RefreshHandle identifies the refresh triggered by a specific draw,
+// so that it can be waited for without relying on GetLastMarker (which
+// another goroutine may have moved on in the meantime).
+// The *Tracked variants of the drawing methods return one:
+//
+//	h, err := fb.PrintImageTracked("page.png", 0, 0, &cfg)
+//	...
+//	err = h.Wait(ctx)
+type RefreshHandle struct {
+	Marker  uint32    // The refresh's marker, or LastMarker if the draw didn't refresh anything
+	Rect    FBInkRect // The area drawn to
+	backend Backend
+	once    sync.Once
+	done    chan struct{}
+	err     error
+}
+
+// track runs draw on the render goroutine, like batch, and returns a
+// handle for the refresh it triggered, which is looked up in the same
+// batch, before anything else gets drawn
+func (f *FBInk) track(draw func(b Backend) error) (h *RefreshHandle, err error) {
+	err = f.batch(func(b Backend) error {
+		before := b.LastMarker()
+		err := draw(b)
+		h = &RefreshHandle{Marker: LastMarker, Rect: b.LastRect(), backend: b}
+		if marker := b.LastMarker(); marker != before {
+			h.Marker = marker
+		}
+		return err
+	})
+	return h, err
+}
+
+// FBprintTracked is FBprint, also returning a handle for its refresh
+func (f *FBInk) FBprintTracked(str string, cfg *FBInkConfig) (rows int, h *RefreshHandle, err error) {
+	h, err = f.track(func(b Backend) (err error) {
+		rows, err = b.Print(str, cfg)
+		return err
+	})
+	return rows, h, err
+}
+
+// PrintOTTracked is PrintOT, also returning a handle for its refresh
+func (f *FBInk) PrintOTTracked(str string, otCfg *FBInkOTConfig, fbCfg *FBInkConfig) (top int, fit FBInkOTFit, h *RefreshHandle, err error) {
+	h, err = f.track(func(b Backend) (err error) {
+		top, fit, err = b.PrintOT(str, otCfg, fbCfg)
+		return err
+	})
+	return top, fit, h, err
+}
+
+// PrintImageTracked is PrintImage, also returning a handle for its refresh
+func (f *FBInk) PrintImageTracked(imgPath string, targX, targY int16, cfg *FBInkConfig) (*RefreshHandle, error) {
+	return f.track(func(b Backend) error {
+		return b.PrintImage(imgPath, targX, targY, cfg)
+	})
+}
+
+// PrintRawDataTracked is PrintRawData, also returning a handle for its refresh
+func (f *FBInk) PrintRawDataTracked(data []byte, w, h int, xOff, yOff uint16, cfg *FBInkConfig) (*RefreshHandle, error) {
+	return f.track(func(b Backend) error {
+		return b.PrintRawData(data, w, h, int16(xOff), int16(yOff), cfg)
+	})
+}
+
+// PrintGoImageTracked is PrintGoImage, also returning a handle for its refresh
+func (f *FBInk) PrintGoImageTracked(xOff, yOff int16, im image.Image, cfg *FBInkConfig) (*RefreshHandle, error) {
+	data, w, h, rawCfg, err := f.goImageData(im, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return f.track(func(b Backend) error {
+		return b.PrintRawData(data, w, h, xOff, yOff, rawCfg)
+	})
+}
+
+// ClearScreenTracked is ClearScreen, also returning a handle for its refresh
+func (f *FBInk) ClearScreenTracked(cfg *FBInkConfig, rect *FBInkRect) (*RefreshHandle, error) {
+	return f.track(func(b Backend) error {
+		return b.ClearScreen(cfg, rect)
+	})
+}
+
+// RefreshTracked is Refresh, also returning a handle for it
+func (f *FBInk) RefreshTracked(top, left, width, height uint32, cfg *FBInkConfig) (*RefreshHandle, error) {
+	return f.track(func(b Backend) error {
+		return b.Refresh(top, left, width, height, cfg)
+	})
+}
+
+// start waits for the completion of the refresh in the background, once
+func (h *RefreshHandle) start() {
+	h.once.Do(func() {
+		h.done = make(chan struct{})
+		if h.Marker == LastMarker {
+			close(h.done)
+			return
+		}
+		go func() {
+			h.err = h.backend.WaitForCompletion(h.Marker)
+			close(h.done)
+		}()
+	})
+}
+
+// Wait blocks until the refresh has completed, or ctx is done. In the
+// latter case, it returns ctx.Err(), and the actual wait goes on in the
+// background (a stuck EPDC can't be interrupted), so that a later Wait
+// or Done still reports the outcome.
+// Waiting for a draw that didn't refresh anything returns nil right away.
+func (h *RefreshHandle) Wait(ctx context.Context) error {
+	h.start()
+	select {
+	case <-h.done:
+		return h.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done returns a channel which receives the outcome of the refresh (see
+// WaitForCompletion) once it has completed, and is then closed
+func (h *RefreshHandle) Done() <-chan error {
+	h.start()
+	ch := make(chan error, 1)
+	go func() {
+		<-h.done
+		ch <- h.err
+		close(ch)
+	}()
+	return ch
+}
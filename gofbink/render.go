@@ -0,0 +1,104 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+// FBInk sessions are safe for concurrent use: every call that draws, or
+// otherwise touches the framebuffer (or libfbink's global state), is queued
+// and executed in order by a single render goroutine, which owns the
+// backend. The caller blocks until its call has been executed, and gets its
+// result back as usual.
+// Calls are executed one at a time, so a long running one (eg: ButtonScan)
+// holds up the queue. WaitForSubmission & WaitForCompletion bypass it, so
+// that waiting for a refresh doesn't prevent other goroutines from drawing.
+
+// renderOp is a queued call
+type renderOp struct {
+	fn    func()
+	done  chan struct{}
+	panic interface{}
+}
+
+// do runs fn on the render goroutine, and waits for it to be done.
+// fn must not call any of the queued FBInk methods, or it would deadlock:
+// use the backend directly instead.
+func (f *FBInk) do(fn func()) {
+	op := &renderOp{fn: func() {
+		if f.watcher != nil && f.watcher.beforeDraw {
+			f.watcher.check()
+		}
+		fn()
+	}, done: make(chan struct{})}
+	f.queueMu.RLock()
+	if f.queue == nil {
+		// Lazily (re)start the render goroutine, which Close stops
+		f.queueMu.RUnlock()
+		f.queueMu.Lock()
+		if f.queue == nil {
+			f.queue = make(chan *renderOp)
+			go render(f.queue)
+		}
+		f.queueMu.Unlock()
+		f.queueMu.RLock()
+	}
+	f.queue <- op
+	f.queueMu.RUnlock()
+	<-op.done
+	if op.panic != nil {
+		// Don't let the caller's bug take the render goroutine down
+		panic(op.panic)
+	}
+}
+
+// batch runs fn on the render goroutine, with direct access to the
+// backend, so that a draw made of several calls (eg: setting the pens,
+// printing, then restoring them) isn't interleaved with other drawing.
+// Like with do, fn must not call any of the queued FBInk methods.
+func (f *FBInk) batch(fn func(b Backend) error) (err error) {
+	f.do(func() { err = fn(f.backend) })
+	return err
+}
+
+// stop stops the render goroutine, once the queued calls are done
+func (f *FBInk) stop() {
+	f.queueMu.Lock()
+	defer f.queueMu.Unlock()
+	if f.queue != nil {
+		close(f.queue)
+		f.queue = nil
+	}
+}
+
+func render(queue <-chan *renderOp) {
+	for op := range queue {
+		op.run()
+	}
+}
+
+func (op *renderOp) run() {
+	defer close(op.done)
+	defer func() {
+		op.panic = recover()
+	}()
+	op.fn()
+}
@@ -0,0 +1,134 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"image"
+	"sync"
+	"testing"
+)
+
+func newTestSession(t *testing.T) (*FBInk, *VirtualFB, FBInkConfig) {
+	t.Helper()
+	v := NewVirtualFB(160, 240, 150, 0)
+	cfg := FBInkConfig{}
+	f := NewWithBackend(v, &cfg, &RestrictedConfig{})
+	t.Cleanup(func() { f.Close() })
+	return f, v, cfg
+}
+
+func TestBatchIsNotInterleaved(t *testing.T) {
+	f, _, cfg := newTestSession(t)
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		other := cfg
+		other.Row = 10
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				f.FBprint("noise", &other)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		err := f.batch(func(b Backend) error {
+			c := cfg
+			if _, err := b.Print("A", &c); err != nil {
+				return err
+			}
+			// Nothing else may have been drawn since
+			if r := b.LastRect(); r != fbRect(0, 0, 16, 16) {
+				t.Errorf("last rect = %v, want the batch's own", r)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestTracked(t *testing.T) {
+	f, v, cfg := newTestSession(t)
+	_, h, err := f.FBprintTracked("AB", &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Marker != v.LastMarker() || h.Rect != fbRect(0, 0, 32, 16) {
+		t.Errorf("handle marker %d, rect %v", h.Marker, h.Rect)
+	}
+	// Draws that don't refresh anything get a handle that's already done
+	cfg.NoRefresh = true
+	h, err = f.ClearScreenTracked(&cfg, &FBInkRect{Width: 8, Height: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Marker != LastMarker || h.Rect != fbRect(0, 0, 8, 8) {
+		t.Errorf("handle marker %d, rect %v, want LastMarker", h.Marker, h.Rect)
+	}
+	if err := <-h.Done(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTrackedRefresh(t *testing.T) {
+	f, v, cfg := newTestSession(t)
+	h, err := f.RefreshTracked(16, 8, 32, 32, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := v.Refreshes(); len(r) != 1 || r[0] != fbRect(8, 16, 32, 32) {
+		t.Errorf("refreshes = %v", r)
+	}
+	f.ClearScreen(&cfg, nil)
+	// The handle still points at its own refresh
+	if h.Marker != 1 || v.LastMarker() != 2 {
+		t.Errorf("handle marker %d, last marker %d", h.Marker, v.LastMarker())
+	}
+}
+
+func TestNewWithBackendDefaults(t *testing.T) {
+	f, v, _ := newTestSession(t)
+	var cfg FBInkConfig
+	f.do(func() { cfg = f.internCfg })
+	if cfg.Row != 1 || cfg.Col != 1 {
+		t.Errorf("internal config at row %d, col %d, want 1, 1", cfg.Row, cfg.Col)
+	}
+	// The default console positions its rows itself
+	if _, err := f.Println("X"); err != nil {
+		t.Fatal(err)
+	}
+	checkPixels(t, v.Image(), map[image.Point]uint8{
+		{2, 4*16 + 2}:  0x00,
+		{18, 4*16 + 2}: 0xFF,
+		{2, 5*16 + 2}:  0xFF,
+	})
+}
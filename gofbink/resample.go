@@ -0,0 +1,231 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"math"
+)
+
+// ScaleMode type
+type ScaleMode uint8
+
+// ScaleMode constants, for Go-side scaling (see FBInkConfig.GoScale)
+const (
+	ScaleNone    ScaleMode = iota
+	ScaleFit               // As large as fits, keeping the aspect ratio
+	ScaleFill              // Cover the target, keeping the aspect ratio, and crop
+	ScaleStretch           // Exactly the target size
+)
+
+// Filter type
+type Filter uint8
+
+// Filter constants, for Go-side scaling
+const (
+	FilterBilinear Filter = iota
+	FilterLanczos         // Lanczos3, the sharpest, and the slowest
+	FilterBox             // Area averaging, for large downscales
+)
+
+// scaledSize returns the size an image of w x h is scaled to, to be
+// printed in a tw x th target
+func scaledSize(w, h, tw, th int, mode ScaleMode) (sw, sh int) {
+	if mode == ScaleStretch {
+		return tw, th
+	}
+	sx, sy := float64(tw)/float64(w), float64(th)/float64(h)
+	s := math.Min(sx, sy)
+	if mode == ScaleFill {
+		s = math.Max(sx, sy)
+	}
+	sw, sh = int(float64(w)*s+0.5), int(float64(h)*s+0.5)
+	return maxInt(sw, 1), maxInt(sh, 1)
+}
+
+// scaleData scales packed Y8 or Y8A8 data to fit a tw x th target.
+// With ScaleFill, the excess is cropped according to halign & valign.
+func scaleData(data []byte, w, h, tw, th int, mode ScaleMode, filter Filter, halign, valign Align) ([]byte, int, int) {
+	ch := len(data) / (w * h)
+	sw, sh := scaledSize(w, h, tw, th, mode)
+	if sw != w || sh != h {
+		data = resample(data, w, h, ch, sw, sh, filter)
+	}
+	if mode != ScaleFill || (sw <= tw && sh <= th) {
+		return data, sw, sh
+	}
+	cw, chh := minInt(sw, tw), minInt(sh, th)
+	x0, y0 := alignOffset(sw-cw, halign), alignOffset(sh-chh, valign)
+	cropped := make([]byte, cw*chh*ch)
+	for y := 0; y < chh; y++ {
+		copy(cropped[y*cw*ch:(y+1)*cw*ch], data[((y0+y)*sw+x0)*ch:])
+	}
+	return cropped, cw, chh
+}
+
+// alignOffset returns where to start in excess pixels, to keep the part of
+// the image matching its alignment
+func alignOffset(excess int, align Align) int {
+	switch align {
+	case Center:
+		return excess / 2
+	case Edge:
+		return excess
+	}
+	return 0
+}
+
+// resampleFilter is a separable filter kernel, of support (radius) pixels
+type resampleFilter struct {
+	support float64
+	kernel  func(x float64) float64
+}
+
+var resampleFilters = map[Filter]resampleFilter{
+	FilterBilinear: {1, func(x float64) float64 {
+		return 1 - math.Abs(x)
+	}},
+	FilterLanczos: {3, func(x float64) float64 {
+		return sinc(x) * sinc(x/3)
+	}},
+	FilterBox: {0.5, func(x float64) float64 {
+		if x >= -0.5 && x < 0.5 {
+			return 1
+		}
+		return 0
+	}},
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// contrib are the weights of the input pixels making up an output pixel
+type contrib struct {
+	start   int
+	weights []float32
+}
+
+// contribs computes the weights along one axis, scaling in to out pixels
+func contribs(in, out int, f resampleFilter) []contrib {
+	scale := float64(in) / float64(out)
+	// When downscaling, widen the filter to cover all the input pixels
+	fscale := math.Max(scale, 1)
+	support := f.support * fscale
+	cs := make([]contrib, out)
+	for o := range cs {
+		center := (float64(o) + 0.5) * scale
+		start := maxInt(int(math.Floor(center-support)), 0)
+		end := minInt(int(math.Ceil(center+support)), in)
+		var sum float64
+		weights := make([]float64, 0, end-start)
+		for i := start; i < end; i++ {
+			w := f.kernel((float64(i) + 0.5 - center) / fscale)
+			weights = append(weights, w)
+			sum += w
+		}
+		c := contrib{start: start, weights: make([]float32, len(weights))}
+		for i, w := range weights {
+			if sum != 0 {
+				w /= sum
+			}
+			c.weights[i] = float32(w)
+		}
+		cs[o] = c
+	}
+	return cs
+}
+
+// resample scales packed data of ch channels (Y8 or Y8A8) from w x h to
+// sw x sh, in two separable passes
+func resample(data []byte, w, h, ch, sw, sh int, filter Filter) []byte {
+	f, ok := resampleFilters[filter]
+	if !ok {
+		f = resampleFilters[FilterBilinear]
+	}
+	src := make([]float32, len(data))
+	for i, v := range data {
+		src[i] = float32(v)
+	}
+	if ch == 2 {
+		// Premultiply, so that transparent pixels don't bleed into the
+		// others
+		for i := 0; i < len(src); i += 2 {
+			src[i] *= src[i+1] / 0xFF
+		}
+	}
+
+	// Horizontal pass, to sw x h
+	tmp := make([]float32, sw*h*ch)
+	for x, c := range contribs(w, sw, f) {
+		for y := 0; y < h; y++ {
+			row := src[(y*w+c.start)*ch:]
+			for k := 0; k < ch; k++ {
+				var v float32
+				for i, wt := range c.weights {
+					v += row[i*ch+k] * wt
+				}
+				tmp[(y*sw+x)*ch+k] = v
+			}
+		}
+	}
+	// Vertical pass, to sw x sh
+	dst := make([]float32, sw*sh*ch)
+	for y, c := range contribs(h, sh, f) {
+		for i, wt := range c.weights {
+			row := tmp[(c.start+i)*sw*ch : (c.start+i+1)*sw*ch]
+			out := dst[y*sw*ch : (y+1)*sw*ch]
+			for j, v := range row {
+				out[j] += v * wt
+			}
+		}
+	}
+
+	out := make([]byte, len(dst))
+	for i := 0; i < len(dst); i += ch {
+		if ch == 2 {
+			// Unpremultiply by the unrounded alpha, as rounding it would
+			// skew the gray of nearly transparent pixels
+			if a := dst[i+1]; a > 0 {
+				dst[i] *= 0xFF / a
+			}
+			out[i+1] = clamp8(dst[i+1])
+		}
+		out[i] = clamp8(dst[i])
+	}
+	return out
+}
+
+func clamp8(v float32) byte {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 0xFF:
+		return 0xFF
+	}
+	return byte(v + 0.5)
+}
@@ -0,0 +1,138 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestScaledSize(t *testing.T) {
+	tests := []struct {
+		w, h, tw, th int
+		mode         ScaleMode
+		sw, sh       int
+	}{
+		{200, 100, 100, 100, ScaleFit, 100, 50},
+		{200, 100, 100, 100, ScaleFill, 200, 100},
+		{200, 100, 100, 100, ScaleStretch, 100, 100},
+		{10, 20, 100, 100, ScaleFit, 50, 100},
+		{10, 20, 100, 100, ScaleFill, 100, 200},
+		{10, 20, 100, 100, ScaleStretch, 100, 100},
+		{3, 3, 10, 10, ScaleFit, 10, 10},
+		{1000, 1, 10, 10, ScaleFit, 10, 1}, // Never down to 0
+		{1000, 1, 10, 10, ScaleFill, 10000, 10},
+	}
+	for _, tt := range tests {
+		if sw, sh := scaledSize(tt.w, tt.h, tt.tw, tt.th, tt.mode); sw != tt.sw || sh != tt.sh {
+			t.Errorf("scaledSize(%d, %d, %d, %d, %d) = %dx%d, want %dx%d", tt.w, tt.h, tt.tw, tt.th, tt.mode, sw, sh, tt.sw, tt.sh)
+		}
+	}
+}
+
+func TestScaleDataCrop(t *testing.T) {
+	// 4x2 and 2x4, with each pixel set to 10*x + y, are filled into 2x2
+	// without any scaling, so only the crop offset matters
+	wide := []byte{0, 10, 20, 30, 1, 11, 21, 31}
+	tall := []byte{0, 10, 1, 11, 2, 12, 3, 13}
+	tests := []struct {
+		name   string
+		data   []byte
+		w, h   int
+		halign Align
+		valign Align
+		want   []byte
+	}{
+		{"left", wide, 4, 2, AlignNone, AlignNone, []byte{0, 10, 1, 11}},
+		{"center", wide, 4, 2, Center, AlignNone, []byte{10, 20, 11, 21}},
+		{"right", wide, 4, 2, Edge, AlignNone, []byte{20, 30, 21, 31}},
+		{"top", tall, 2, 4, AlignNone, AlignNone, []byte{0, 10, 1, 11}},
+		{"middle", tall, 2, 4, AlignNone, Center, []byte{1, 11, 2, 12}},
+		{"bottom", tall, 2, 4, Center, Edge, []byte{2, 12, 3, 13}},
+	}
+	for _, tt := range tests {
+		got, w, h := scaleData(tt.data, tt.w, tt.h, 2, 2, ScaleFill, FilterBilinear, tt.halign, tt.valign)
+		if w != 2 || h != 2 || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: scaleData = %v (%dx%d), want %v (2x2)", tt.name, got, w, h, tt.want)
+		}
+	}
+
+	// Y8A8 is cropped by whole pixels
+	ya := []byte{0, 0xFF, 10, 0x80, 20, 0x40, 30, 0}
+	if got, want := mustScale(t, ya, 4, 1, 2, 1, Edge), []byte{20, 0x40, 30, 0}; !bytes.Equal(got, want) {
+		t.Errorf("scaleData (Y8A8) = %v, want %v", got, want)
+	}
+}
+
+func mustScale(t *testing.T, data []byte, w, h, tw, th int, halign Align) []byte {
+	t.Helper()
+	got, sw, sh := scaleData(data, w, h, tw, th, ScaleFill, FilterBilinear, halign, AlignNone)
+	if sw != tw || sh != th {
+		t.Fatalf("scaleData size = %dx%d, want %dx%d", sw, sh, tw, th)
+	}
+	return got
+}
+
+func TestScaleDataFit(t *testing.T) {
+	data := bytes.Repeat([]byte{0x80}, 40*20)
+	got, w, h := scaleData(data, 40, 20, 16, 16, ScaleFit, FilterLanczos, Center, Center)
+	if w != 16 || h != 8 {
+		t.Fatalf("scaleData size = %dx%d, want 16x8", w, h)
+	}
+	// A flat image stays flat, whatever the filter
+	if want := bytes.Repeat([]byte{0x80}, 16*8); !bytes.Equal(got, want) {
+		t.Errorf("scaleData = %v, want all 0x80", got)
+	}
+}
+
+func TestResamplePremultiplied(t *testing.T) {
+	// The left half is opaque white, the right half transparent black:
+	// resampling mustn't darken the edge
+	const w, h = 8, 8
+	data := make([]byte, w*h*2)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w/2; x++ {
+			data[(y*w+x)*2], data[(y*w+x)*2+1] = 0xFF, 0xFF
+		}
+	}
+	for _, filter := range []Filter{FilterBilinear, FilterLanczos, FilterBox} {
+		for _, size := range [][2]int{{13, 13}, {5, 5}, {3, 8}} {
+			out := resample(data, w, h, 2, size[0], size[1], filter)
+			partial, dark := false, -1
+			for i := 0; i < len(out); i += 2 {
+				if out[i+1] > 0 && out[i] != 0xFF && dark < 0 {
+					dark = i
+				}
+				partial = partial || (out[i+1] > 0 && out[i+1] < 0xFF)
+			}
+			if dark >= 0 {
+				t.Errorf("filter %d, %dx%d: pixel %d = %#x, alpha %#x, want white", filter, size[0], size[1], dark/2, out[dark], out[dark+1])
+			}
+			// Box upscaling amounts to nearest neighbor, without any blending
+			if !partial && (filter != FilterBox || size[0] < w) {
+				t.Errorf("filter %d, %dx%d: no partially transparent edge", filter, size[0], size[1])
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// Image converts the dump to an image.Image, exactly as it is laid out in
+// framebuffer memory. 4bpp and 8bpp dumps become an *image.Gray, 16, 24
+// and 32bpp dumps an *image.RGBA. The image bounds match the dump's Area,
+// so a region dump keeps its screen coordinates.
+// Use FBInk.DumpImage if you want the image as it appears on screen.
+func (d *FBInkDump) Image() (image.Image, error) {
+	data := d.bytes()
+	if data == nil {
+		return nil, errors.New("dump has no data")
+	}
+	w, h := int(d.Area.Width), int(d.Area.Height)
+	stride := int(d.Stride)
+	rowLen := (w*int(d.BPP) + 7) / 8
+	if stride < rowLen || len(data) < stride*(h-1)+rowLen {
+		return nil, fmt.Errorf("dump data too short for a %dx%d@%dbpp area", w, h, d.BPP)
+	}
+	rect := image.Rect(0, 0, w, h).Add(image.Pt(int(d.Area.Left), int(d.Area.Top)))
+	switch d.BPP {
+	case 4:
+		img := image.NewGray(rect)
+		for y := 0; y < h; y++ {
+			row := data[y*stride:]
+			pix := img.Pix[y*img.Stride:]
+			for x := 0; x < w; x++ {
+				// Even pixels live in the high nibble
+				v := row[x>>1]
+				if x&1 == 0 {
+					v >>= 4
+				}
+				pix[x] = (v & 0x0F) * 0x11
+			}
+		}
+		return img, nil
+	case 8:
+		img := image.NewGray(rect)
+		for y := 0; y < h; y++ {
+			copy(img.Pix[y*img.Stride:y*img.Stride+w], data[y*stride:])
+		}
+		return img, nil
+	case 16:
+		img := image.NewRGBA(rect)
+		for y := 0; y < h; y++ {
+			row := data[y*stride:]
+			pix := img.Pix[y*img.Stride:]
+			for x := 0; x < w; x++ {
+				v := uint16(row[x*2]) | uint16(row[x*2+1])<<8
+				r := uint8(v >> 11 & 0x1F)
+				g := uint8(v >> 5 & 0x3F)
+				b := uint8(v & 0x1F)
+				pix[x*4] = r<<3 | r>>2
+				pix[x*4+1] = g<<2 | g>>4
+				pix[x*4+2] = b<<3 | b>>2
+				pix[x*4+3] = 0xFF
+			}
+		}
+		return img, nil
+	case 24, 32:
+		img := image.NewRGBA(rect)
+		bypp := int(d.BPP) / 8
+		for y := 0; y < h; y++ {
+			row := data[y*stride:]
+			pix := img.Pix[y*img.Stride:]
+			for x := 0; x < w; x++ {
+				// The framebuffer is BGR(A), and we ignore its alpha
+				pix[x*4] = row[x*bypp+2]
+				pix[x*4+1] = row[x*bypp+1]
+				pix[x*4+2] = row[x*bypp]
+				pix[x*4+3] = 0xFF
+			}
+		}
+		return img, nil
+	default:
+		return nil, fmt.Errorf("unsupported dump bitdepth: %d", d.BPP)
+	}
+}
+
+// DumpImage converts a dump to an image.Image as it appears on screen.
+// On top of what FBInkDump.Image does, this undoes the coordinates
+// rotation FBInk applies on Kobos with a quirky landscape framebuffer,
+// and the inverted palette of legacy Kindles.
+func (f *FBInk) DumpImage(dump *FBInkDump) (image.Image, error) {
+	img, err := dump.Image()
+	if err != nil {
+		return nil, err
+	}
+	state := FBInkState{}
+	f.GetState(&f.internCfg, &state)
+	if state.IsKindleLegacy {
+		if gray, ok := img.(*image.Gray); ok {
+			for i := range gray.Pix {
+				gray.Pix[i] ^= 0xFF
+			}
+		}
+	}
+	// The dump has to have been taken in the current rotation for the
+	// quirk to apply, much like Restore requires
+	if state.IsNTXQuirkyLandscape && f.RotaNativeToCanonical(uint32(dump.Rota)) == state.CurrentRota {
+		img = rotateCW(img)
+	}
+	return img, nil
+}
+
+// rotateCW returns a copy of img rotated by 90° clockwise, with its
+// origin at (0, 0)
+func rotateCW(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	rect := image.Rect(0, 0, h, w)
+	var dst interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	if _, ok := img.(*image.Gray); ok {
+		dst = image.NewGray(rect)
+	} else {
+		dst = image.NewRGBA(rect)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(h-1-y, x, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// Screenshot dumps the whole screen and returns it as an image.Image
+// See DumpImage for details about the conversion
+func (f *FBInk) Screenshot() (image.Image, error) {
+	dump, err := f.Dump()
+	if err != nil {
+		return nil, err
+	}
+	defer dump.Free()
+	return f.DumpImage(dump)
+}
+
+// ScreenshotPNG writes a PNG encoded screenshot to w
+func (f *FBInk) ScreenshotPNG(w io.Writer) error {
+	img, err := f.Screenshot()
+	if err != nil {
+		return err
+	}
+	return WritePNG(w, img)
+}
+
+// WritePNG encodes img as a PNG to w. Grayscale images stay grayscale,
+// which keeps screenshots of eInk screens small.
+func WritePNG(w io.Writer, img image.Image) error {
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	return enc.Encode(w, img)
+}
@@ -0,0 +1,150 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"strings"
+)
+
+// StatusLine is a row pinned to the top (header) or bottom (footer) of the
+// screen, split in left, center and right-aligned segments (eg: a title, a
+// clock and a battery level).
+// The rows of the session's status lines are left alone by its consoles
+// (but not by panes, or regular prints), which scroll between them.
+type StatusLine struct {
+	f      *FBInk
+	footer bool
+	index  int // Amongst the headers or footers, from the edge
+
+	// Set on the render goroutine
+	left, center, right string
+	shownRow            int
+	shown               string
+}
+
+// NewHeader pins a new status line to the top of the screen, below the
+// previous headers
+func (f *FBInk) NewHeader() (s *StatusLine) {
+	f.do(func() {
+		s = &StatusLine{f: f, index: f.headers, shownRow: -1}
+		f.headers++
+	})
+	return s
+}
+
+// NewFooter pins a new status line to the bottom of the screen, above the
+// previous footers
+func (f *FBInk) NewFooter() (s *StatusLine) {
+	f.do(func() {
+		s = &StatusLine{f: f, footer: true, index: f.footers, shownRow: -1}
+		f.footers++
+	})
+	return s
+}
+
+// Set updates the segments of the status line, and redraws it if it
+// changed. Only the status line is refreshed. The center segment is moved
+// off center rather than overlapping the others. When the segments don't
+// fit, the center one is dropped first, then the left one is truncated.
+func (s *StatusLine) Set(left, center, right string) (err error) {
+	s.f.do(func() {
+		s.left, s.center, s.right = left, center, right
+		err = s.draw()
+	})
+	return err
+}
+
+// Redraw redraws the status line, eg: after clearing the screen
+func (s *StatusLine) Redraw() (err error) {
+	s.f.do(func() {
+		s.shown = ""
+		err = s.draw()
+	})
+	return err
+}
+
+// draw runs on the render goroutine
+func (s *StatusLine) draw() error {
+	state := FBInkState{}
+	s.f.backend.State(&s.f.internCfg, &state)
+	row, cols := s.index, int(state.MaxCols)
+	if s.footer {
+		row = int(state.MaxRows) - 1 - s.index
+	}
+	if row < 0 || row >= int(state.MaxRows) || cols < 1 {
+		return createError("fbink_print", eRange)
+	}
+	text := s.compose(cols, s.f.internCfg.fontname == UnifontDW)
+	if row == s.shownRow && text == s.shown {
+		return nil
+	}
+	cfg := s.f.internCfg
+	cfg.Row, cfg.Col = int16(row), 0
+	cfg.Hoffset, cfg.Voffset = 0, 0
+	cfg.NoRefresh = true
+	if _, err := s.f.backend.Print(text, &cfg); err != nil {
+		s.shown = ""
+		return err
+	}
+	s.shownRow, s.shown = row, text
+	// Only refresh our own row (ViewVertOrigin includes ViewVertOffset)
+	top := int(state.ViewVertOrigin) + row*int(state.FontH)
+	return s.f.backend.Refresh(uint32(top), uint32(state.ViewHoriOrigin), uint32(cols)*uint32(state.FontW), uint32(state.FontH), &s.f.internCfg)
+}
+
+// compose lays out the segments on a row of cols cells, as cellText
+func (s *StatusLine) compose(cols int, dwFont bool) string {
+	cells := func(str string) []rune {
+		return []rune(cellText(strings.Join(strings.Fields(str), " "), dwFont))
+	}
+	left, center, right := cells(s.left), cells(s.center), cells(s.right)
+	if len(right) > cols {
+		right = right[len(right)-cols:]
+	}
+	// Keep the center segment clear of the others, at least a cell apart,
+	// shifting it off center if need be
+	minStart, maxStart := len(left), cols-len(right)-len(center)
+	if len(left) > 0 {
+		minStart++
+	}
+	if len(right) > 0 {
+		maxStart--
+	}
+	start := (cols - len(center)) / 2
+	if minStart > maxStart {
+		center = nil
+	} else if start < minStart {
+		start = minStart
+	} else if start > maxStart {
+		start = maxStart
+	}
+	if maxLeft := cols - len(right) - 1; len(left) > maxLeft {
+		left = left[:maxInt(maxLeft, 0)]
+	}
+	row := []rune(strings.Repeat(" ", cols))
+	copy(row, left)
+	copy(row[start:], center)
+	copy(row[cols-len(right):], right)
+	return string(row)
+}
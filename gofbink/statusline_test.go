@@ -0,0 +1,76 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"testing"
+)
+
+func TestStatusLineCompose(t *testing.T) {
+	tests := []struct {
+		name                string
+		left, center, right string
+		cols                int
+		want                string
+	}{
+		{"centered", "ab", "cd", "ef", 10, "ab  cd  ef"},
+		{"long left", "0123456789", "CNTR", "rr", 20, "0123456789 CNTR   rr"},
+		{"long right", "ll", "CNTR", "0123456789", 20, "ll   CNTR 0123456789"},
+		{"no left", "", "CNTR", "0123456789abcd", 20, " CNTR 0123456789abcd"},
+		{"no right", "0123456789abcd", "CNTR", "", 20, "0123456789abcd CNTR "},
+		{"tight", "0123", "CNTR", "rr", 12, "0123 CNTR rr"},
+		{"center dropped", "01234", "CNTR", "rr", 12, "01234     rr"},
+		{"left truncated", "0123456789", "", "rr", 8, "01234 rr"},
+		{"right only", "", "", "0123456789", 8, "23456789"},
+		{"center only", "", "CNTR", "", 4, "CNTR"},
+		{"spaces collapsed", " a  b ", "", "", 5, "a b  "},
+	}
+	for _, tt := range tests {
+		s := &StatusLine{left: tt.left, center: tt.center, right: tt.right}
+		if got := s.compose(tt.cols, false); got != tt.want {
+			t.Errorf("%s: compose = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStatusLineViewportOffset(t *testing.T) {
+	// Row 0 starts at y 7, which includes a row balancing offset of 4
+	for _, offset := range []uint8{0, 4} {
+		v := NewVirtualFB(160, 240, 150, 0)
+		cfg := FBInkConfig{}
+		f := NewWithBackend(originFB{v, 3, 7, offset}, &cfg, &RestrictedConfig{})
+		defer f.Close()
+		header, footer := f.NewHeader(), f.NewFooter()
+		v.ResetRefreshes()
+		if err := header.Set("title", "", "12:00"); err != nil {
+			t.Fatal(err)
+		}
+		if err := footer.Set("", "page 1", ""); err != nil {
+			t.Fatal(err)
+		}
+		// The virtual framebuffer isn't any larger for the origin, so the
+		// refreshes are clipped to the screen
+		checkRefreshes(t, v, fbRect(3, 7, 157, 16), fbRect(3, 7+14*16, 157, 9))
+	}
+}
@@ -0,0 +1,719 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"errors"
+	"image/color"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// termAttr is the SGR state of a cell
+type termAttr struct {
+	bold    bool
+	inverse bool
+	fgSet   bool // Whether fg/bg override the session's pen colors
+	bgSet   bool
+	fg      uint8
+	bg      uint8
+}
+
+type termCell struct {
+	r    rune
+	attr termAttr
+}
+
+// Parser states
+const (
+	termGround = iota
+	termEscape
+	termCharset // ESC ( and friends, which take one more byte
+	termCSI
+	termOSC
+	termOSCEscape
+)
+
+// ansiColors are the xterm colors of the 16 ANSI color indexes
+var ansiColors = [16]color.RGBA{
+	{0, 0, 0, 0xFF}, {205, 0, 0, 0xFF}, {0, 205, 0, 0xFF}, {205, 205, 0, 0xFF},
+	{0, 0, 238, 0xFF}, {205, 0, 205, 0xFF}, {0, 205, 205, 0xFF}, {229, 229, 229, 0xFF},
+	{127, 127, 127, 0xFF}, {255, 0, 0, 0xFF}, {0, 255, 0, 0xFF}, {255, 255, 0, 0xFF},
+	{92, 92, 255, 0xFF}, {255, 0, 255, 0xFF}, {0, 255, 255, 0xFF}, {255, 255, 255, 0xFF},
+}
+
+// xtermGray returns the gray level of an xterm 256 color index
+func xtermGray(n int) uint8 {
+	var c color.RGBA
+	switch {
+	case n < 16:
+		c = ansiColors[n]
+	case n < 232:
+		levels := [6]uint8{0, 95, 135, 175, 215, 255}
+		n -= 16
+		c = color.RGBA{levels[n/36], levels[n/6%6], levels[n%6], 0xFF}
+	default:
+		g := uint8(8 + 10*(n-232))
+		c = color.RGBA{g, g, g, 0xFF}
+	}
+	return color.GrayModel.Convert(c).(color.Gray).Y
+}
+
+// Terminal is an io.Writer emulating a practical subset of a VT100/xterm
+// terminal on screen: cursor movement & addressing, erasing, insertion &
+// deletion of characters and lines, scroll regions, and SGR bold, inverse
+// and (grayscaled) colors.
+// It keeps a grid of cells sized after the session's MaxCols/MaxRows, and
+// redraws the rows that changed at the end of every Write, with a single
+// refresh.
+// Like a tty with ONLCR set, a bare "\n" also returns the cursor to the
+// first column. Colors are applied through the session's pens, which
+// requires libfbink; bold is rendered as a black foreground, as the fixed
+// cell fonts have no bold variant.
+type Terminal struct {
+	mu         sync.Mutex
+	f          *FBInk
+	cfg        FBInkConfig
+	cols       int
+	rows       int
+	cells      [][]termCell
+	dirty      []bool
+	x          int
+	y          int
+	wrapNext   bool // The cursor is past the last column, wrap on the next character
+	savedX     int
+	savedY     int
+	savedAttr  termAttr
+	attr       termAttr
+	top        int // Scroll region, bottom exclusive
+	bottom     int
+	showCursor bool
+	state      int
+	private    bool
+	params     []int
+	partial    []byte // Incomplete UTF-8 sequence from the previous Write
+	noPens     bool
+	penFG      color.Gray // The session's pen colors
+	penBG      color.Gray
+	curFG      color.Gray // The pen colors we last set
+	curBG      color.Gray
+}
+
+// NewTerminal creates a Terminal covering the whole screen. cfg is copied,
+// and used for every print (Row & Col aside).
+func NewTerminal(f *FBInk, cfg *FBInkConfig) *Terminal {
+	t := &Terminal{f: f, cfg: *cfg, showCursor: true}
+	t.cfg.NoRefresh = true
+	state := FBInkState{}
+	f.GetState(cfg, &state)
+	t.cols, t.rows = int(state.MaxCols), int(state.MaxRows)
+	t.penFG, t.penBG = color.Gray{state.PenFGcolor}, color.Gray{state.PenBGcolor}
+	t.curFG, t.curBG = t.penFG, t.penBG
+	if t.cols < 1 {
+		t.cols = 1
+	}
+	if t.rows < 1 {
+		t.rows = 1
+	}
+	t.cells = make([][]termCell, t.rows)
+	for y := range t.cells {
+		t.cells[y] = t.blankRow()
+	}
+	t.dirty = make([]bool, t.rows)
+	t.bottom = t.rows
+	return t
+}
+
+// Size returns the size of the terminal, in cells
+func (t *Terminal) Size() (cols, rows int) {
+	return t.cols, t.rows
+}
+
+func (t *Terminal) blankRow() []termCell {
+	row := make([]termCell, t.cols)
+	for x := range row {
+		row[x] = termCell{r: ' ', attr: termAttr{fgSet: t.attr.fgSet, bgSet: t.attr.bgSet, fg: t.attr.fg, bg: t.attr.bg}}
+	}
+	return row
+}
+
+// Write interprets p, and draws the result
+func (t *Terminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(p)
+	if len(t.partial) > 0 {
+		p = append(t.partial, p...)
+		t.partial = nil
+	}
+	t.markCursor()
+	for len(p) > 0 {
+		r, size := utf8.DecodeRune(p)
+		if r == utf8.RuneError && size == 1 && !utf8.FullRune(p) {
+			t.partial = append([]byte(nil), p...)
+			break
+		}
+		p = p[size:]
+		t.feed(r)
+	}
+	t.markCursor()
+	return n, t.flush()
+}
+
+// Clear resets the terminal, and clears the screen
+func (t *Terminal) Clear() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reset()
+	return t.flush()
+}
+
+func (t *Terminal) reset() {
+	t.attr = termAttr{}
+	t.x, t.y, t.wrapNext = 0, 0, false
+	t.top, t.bottom = 0, t.rows
+	t.showCursor = true
+	t.state = termGround
+	t.eraseRect(0, 0, t.cols, t.rows)
+}
+
+func (t *Terminal) markCursor() {
+	if t.showCursor && t.y < t.rows {
+		t.dirty[t.y] = true
+	}
+}
+
+func (t *Terminal) feed(r rune) {
+	switch t.state {
+	case termEscape:
+		t.escape(r)
+		return
+	case termCharset:
+		t.state = termGround
+		return
+	case termCSI:
+		t.csi(r)
+		return
+	case termOSC:
+		switch r {
+		case '\a':
+			t.state = termGround
+		case 0x1B:
+			t.state = termOSCEscape
+		}
+		return
+	case termOSCEscape:
+		// ESC \ ends the OSC, anything else is a new escape sequence
+		t.state = termGround
+		if r != '\\' {
+			t.state = termEscape
+			t.escape(r)
+		}
+		return
+	}
+	switch r {
+	case 0x1B:
+		t.state = termEscape
+	case '\r':
+		t.x, t.wrapNext = 0, false
+	case '\n', '\v', '\f':
+		t.x = 0
+		t.lineFeed()
+	case '\b':
+		if t.x > 0 {
+			t.x--
+		}
+		t.wrapNext = false
+	case '\t':
+		t.x = (t.x/8 + 1) * 8
+		if t.x >= t.cols {
+			t.x = t.cols - 1
+		}
+	default:
+		if r < 0x20 || r == 0x7F {
+			// Other controls (BEL...) are ignored
+			return
+		}
+		t.put(r)
+	}
+}
+
+// put prints a character at the cursor, and advances it
+func (t *Terminal) put(r rune) {
+	if t.wrapNext {
+		t.x = 0
+		t.lineFeed()
+	}
+	t.cells[t.y][t.x] = termCell{r: r, attr: t.attr}
+	t.dirty[t.y] = true
+	if t.x == t.cols-1 {
+		t.wrapNext = true
+	} else {
+		t.x++
+	}
+}
+
+// lineFeed moves the cursor down, scrolling the scroll region if needed
+func (t *Terminal) lineFeed() {
+	t.wrapNext = false
+	if t.y == t.bottom-1 {
+		t.scrollUp(1)
+	} else if t.y < t.rows-1 {
+		t.y++
+	}
+}
+
+func (t *Terminal) reverseIndex() {
+	t.wrapNext = false
+	if t.y == t.top {
+		t.scrollDown(1)
+	} else if t.y > 0 {
+		t.y--
+	}
+}
+
+// scrollUp scrolls the scroll region up by n lines
+func (t *Terminal) scrollUp(n int) {
+	t.deleteLines(t.top, n)
+}
+
+// scrollDown scrolls the scroll region down by n lines
+func (t *Terminal) scrollDown(n int) {
+	t.insertLines(t.top, n)
+}
+
+// deleteLines deletes n lines at y, pulling up the rest of the scroll region
+func (t *Terminal) deleteLines(y, n int) {
+	if y < t.top || y >= t.bottom {
+		return
+	}
+	if n > t.bottom-y {
+		n = t.bottom - y
+	}
+	copy(t.cells[y:t.bottom], t.cells[y+n:t.bottom])
+	for i := t.bottom - n; i < t.bottom; i++ {
+		t.cells[i] = t.blankRow()
+	}
+	for i := y; i < t.bottom; i++ {
+		t.dirty[i] = true
+	}
+}
+
+// insertLines inserts n blank lines at y, pushing down the rest of the
+// scroll region
+func (t *Terminal) insertLines(y, n int) {
+	if y < t.top || y >= t.bottom {
+		return
+	}
+	if n > t.bottom-y {
+		n = t.bottom - y
+	}
+	copy(t.cells[y+n:t.bottom], t.cells[y:t.bottom-n])
+	for i := y; i < y+n; i++ {
+		t.cells[i] = t.blankRow()
+	}
+	for i := y; i < t.bottom; i++ {
+		t.dirty[i] = true
+	}
+}
+
+// eraseRect blanks the cells from (x0, y0) to (x1, y1) excluded
+func (t *Terminal) eraseRect(x0, y0, x1, y1 int) {
+	blank := t.blankRow()
+	for y := y0; y < y1; y++ {
+		copy(t.cells[y][x0:x1], blank[x0:x1])
+		t.dirty[y] = true
+	}
+}
+
+func (t *Terminal) escape(r rune) {
+	t.state = termGround
+	switch r {
+	case '[':
+		t.state = termCSI
+		t.private = false
+		t.params = t.params[:0]
+	case ']':
+		t.state = termOSC
+	case '(', ')', '*', '+', '#':
+		t.state = termCharset
+	case '7':
+		t.savedX, t.savedY, t.savedAttr = t.x, t.y, t.attr
+	case '8':
+		t.x, t.y, t.attr, t.wrapNext = t.savedX, t.savedY, t.savedAttr, false
+	case 'D':
+		t.lineFeed()
+	case 'E':
+		t.x = 0
+		t.lineFeed()
+	case 'M':
+		t.reverseIndex()
+	case 'c':
+		t.reset()
+	}
+}
+
+// param returns the nth CSI parameter, or def if it's missing or 0
+func (t *Terminal) param(n, def int) int {
+	if n < len(t.params) && t.params[n] != 0 {
+		return t.params[n]
+	}
+	return def
+}
+
+func (t *Terminal) csi(r rune) {
+	switch {
+	case r >= '0' && r <= '9':
+		if len(t.params) == 0 {
+			t.params = append(t.params, 0)
+		}
+		t.params[len(t.params)-1] = t.params[len(t.params)-1]*10 + int(r-'0')
+		return
+	case r == ';' || r == ':':
+		if len(t.params) == 0 {
+			t.params = append(t.params, 0)
+		}
+		t.params = append(t.params, 0)
+		return
+	case r == '?' || r == '>' || r == '=':
+		t.private = true
+		return
+	case r < 0x40:
+		// Intermediate bytes, which we don't care about
+		return
+	}
+	t.state = termGround
+	if t.private {
+		t.privateMode(r)
+		return
+	}
+	n := t.param(0, 1)
+	switch r {
+	case 'A':
+		t.moveTo(t.x, t.y-n)
+	case 'B', 'e':
+		t.moveTo(t.x, t.y+n)
+	case 'C', 'a':
+		t.moveTo(t.x+n, t.y)
+	case 'D':
+		t.moveTo(t.x-n, t.y)
+	case 'E':
+		t.moveTo(0, t.y+n)
+	case 'F':
+		t.moveTo(0, t.y-n)
+	case 'G', '`':
+		t.moveTo(n-1, t.y)
+	case 'd':
+		t.moveTo(t.x, n-1)
+	case 'H', 'f':
+		t.moveTo(t.param(1, 1)-1, n-1)
+	case 'J':
+		switch t.param(0, 0) {
+		case 0:
+			t.eraseRect(t.x, t.y, t.cols, t.y+1)
+			t.eraseRect(0, t.y+1, t.cols, t.rows)
+		case 1:
+			t.eraseRect(0, 0, t.cols, t.y)
+			t.eraseRect(0, t.y, t.x+1, t.y+1)
+		case 2, 3:
+			t.eraseRect(0, 0, t.cols, t.rows)
+		}
+	case 'K':
+		switch t.param(0, 0) {
+		case 0:
+			t.eraseRect(t.x, t.y, t.cols, t.y+1)
+		case 1:
+			t.eraseRect(0, t.y, t.x+1, t.y+1)
+		case 2:
+			t.eraseRect(0, t.y, t.cols, t.y+1)
+		}
+	case 'X':
+		t.eraseRect(t.x, t.y, minInt(t.x+n, t.cols), t.y+1)
+	case 'P':
+		row := t.cells[t.y]
+		n = minInt(n, t.cols-t.x)
+		copy(row[t.x:], row[t.x+n:])
+		copy(row[t.cols-n:], t.blankRow()[:n])
+		t.dirty[t.y] = true
+	case '@':
+		row := t.cells[t.y]
+		n = minInt(n, t.cols-t.x)
+		copy(row[t.x+n:], row[t.x:t.cols-n])
+		copy(row[t.x:t.x+n], t.blankRow()[:n])
+		t.dirty[t.y] = true
+	case 'L':
+		t.insertLines(t.y, n)
+	case 'M':
+		t.deleteLines(t.y, n)
+	case 'S':
+		t.scrollUp(n)
+	case 'T':
+		t.scrollDown(n)
+	case 'm':
+		t.sgr()
+	case 'r':
+		top, bottom := t.param(0, 1)-1, t.param(1, t.rows)
+		if bottom > t.rows {
+			bottom = t.rows
+		}
+		if top < bottom-1 {
+			t.top, t.bottom = top, bottom
+			t.moveTo(0, 0)
+		}
+	case 's':
+		t.savedX, t.savedY = t.x, t.y
+	case 'u':
+		t.moveTo(t.savedX, t.savedY)
+	}
+}
+
+// privateMode handles the DEC private modes we care about
+func (t *Terminal) privateMode(r rune) {
+	set := r == 'h'
+	if r != 'h' && r != 'l' {
+		return
+	}
+	for _, mode := range t.params {
+		switch mode {
+		case 25:
+			t.showCursor = set
+			t.dirty[t.y] = true
+		case 47, 1047, 1049:
+			// No alternate screen, just start from a clean one
+			t.eraseRect(0, 0, t.cols, t.rows)
+		}
+	}
+}
+
+func (t *Terminal) moveTo(x, y int) {
+	t.x = maxInt(0, minInt(x, t.cols-1))
+	t.y = maxInt(0, minInt(y, t.rows-1))
+	t.wrapNext = false
+}
+
+func (t *Terminal) sgr() {
+	if len(t.params) == 0 {
+		t.attr = termAttr{}
+		return
+	}
+	for i := 0; i < len(t.params); i++ {
+		p := t.params[i]
+		switch {
+		case p == 0:
+			t.attr = termAttr{}
+		case p == 1:
+			t.attr.bold = true
+		case p == 22:
+			t.attr.bold = false
+		case p == 7:
+			t.attr.inverse = true
+		case p == 27:
+			t.attr.inverse = false
+		case p >= 30 && p <= 37:
+			t.attr.fgSet, t.attr.fg = true, xtermGray(p-30)
+		case p >= 90 && p <= 97:
+			t.attr.fgSet, t.attr.fg = true, xtermGray(p-90+8)
+		case p == 39:
+			t.attr.fgSet = false
+		case p >= 40 && p <= 47:
+			t.attr.bgSet, t.attr.bg = true, xtermGray(p-40)
+		case p >= 100 && p <= 107:
+			t.attr.bgSet, t.attr.bg = true, xtermGray(p-100+8)
+		case p == 49:
+			t.attr.bgSet = false
+		case p == 38 || p == 48:
+			var g uint8
+			if i+2 < len(t.params) && t.params[i+1] == 5 {
+				g = xtermGray(t.params[i+2] & 0xFF)
+				i += 2
+			} else if i+4 < len(t.params) && t.params[i+1] == 2 {
+				c := color.RGBA{uint8(t.params[i+2]), uint8(t.params[i+3]), uint8(t.params[i+4]), 0xFF}
+				g = color.GrayModel.Convert(c).(color.Gray).Y
+				i += 4
+			} else {
+				return
+			}
+			if p == 38 {
+				t.attr.fgSet, t.attr.fg = true, g
+			} else {
+				t.attr.bgSet, t.attr.bg = true, g
+			}
+		}
+	}
+}
+
+// flush draws the dirty rows, and refreshes them at once. It's done in a
+// single batch, so that the pens it sets are back to the session's by the
+// time anything else gets drawn.
+func (t *Terminal) flush() error {
+	return t.f.batch(func(b Backend) error {
+		first, last := -1, -1
+		var err error
+		for y, dirty := range t.dirty {
+			if !dirty {
+				continue
+			}
+			if first < 0 {
+				first = y
+			}
+			last = y
+			if e := t.drawRow(b, y); e != nil && err == nil {
+				err = e
+			}
+			t.dirty[y] = false
+		}
+		t.restorePens()
+		if first < 0 {
+			return err
+		}
+		cfg := t.cfg
+		cfg.Row, cfg.Col = int16(first), 0
+		if e := b.GridRefresh(uint16(t.cols), uint16(last-first+1), &cfg); e != nil && err == nil {
+			err = e
+		}
+		return err
+	})
+}
+
+// drawRow prints a row, in runs of cells sharing the same attributes
+func (t *Terminal) drawRow(b Backend, y int) error {
+	row := t.cells[y]
+	var sb strings.Builder
+	for x := 0; x < t.cols; {
+		attr := t.cellAttr(x, y)
+		end := x + 1
+		for end < t.cols && t.cellAttr(end, y) == attr {
+			end++
+		}
+		sb.Reset()
+		for _, c := range row[x:end] {
+			sb.WriteRune(c.r)
+		}
+		if err := t.drawRun(b, sb.String(), x, y, attr); err != nil {
+			return err
+		}
+		x = end
+	}
+	return nil
+}
+
+// cellAttr returns the attributes a cell is drawn with, cursor included
+func (t *Terminal) cellAttr(x, y int) termAttr {
+	attr := t.cells[y][x].attr
+	if t.showCursor && x == t.x && y == t.y {
+		attr.inverse = !attr.inverse
+	}
+	return attr
+}
+
+// drawRun prints a run of cells. Plain blank runs are merely cleared, but
+// inverted ones (such as the cursor, on a blank cell) are printed, as
+// GridClear doesn't honor IsInverted everywhere.
+func (t *Terminal) drawRun(b Backend, s string, x, y int, attr termAttr) error {
+	cfg := t.cfg
+	cfg.Row, cfg.Col = int16(y), int16(x)
+	cfg.IsInverted = cfg.IsInverted != attr.inverse
+	if strings.TrimLeft(s, " ") == "" && !attr.bgSet && !attr.inverse {
+		return b.GridClear(uint16(utf8.RuneCountInString(s)), 1, &cfg)
+	}
+	fg, bg := attr.fgSet, attr.bgSet
+	fgColor, bgColor := color.Gray{attr.fg}, color.Gray{attr.bg}
+	if attr.bold && !fg {
+		fg, fgColor = true, FGblack.Gray()
+	}
+	if !t.noPens {
+		t.setPens(fg, fgColor, bg, bgColor)
+	}
+	_, err := b.Print(s, &cfg)
+	return err
+}
+
+// setPens sets (or restores) the session's pens for the next print, from
+// the render goroutine
+func (t *Terminal) setPens(fg bool, fgColor color.Gray, bg bool, bgColor color.Gray) {
+	if !fg {
+		fgColor = t.penFG
+	}
+	if !bg {
+		bgColor = t.penBG
+	}
+	if fgColor == t.curFG && bgColor == t.curBG {
+		return
+	}
+	if err := t.f.setPens(fgColor, bgColor); err != nil {
+		// Without libfbink, there are no pens, and thus no colors
+		if errors.Is(err, ErrNotImplemented) {
+			t.noPens = true
+		}
+		return
+	}
+	t.curFG, t.curBG = fgColor, bgColor
+}
+
+func (t *Terminal) restorePens() {
+	if !t.noPens {
+		t.setPens(false, color.Gray{}, false, color.Gray{})
+	}
+}
+
+// String returns the text currently on the terminal, one line per row,
+// without trailing blanks
+func (t *Terminal) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var sb strings.Builder
+	for y, row := range t.cells {
+		line := make([]rune, len(row))
+		for x, c := range row {
+			line[x] = c.r
+		}
+		sb.WriteString(strings.TrimRight(string(line), " "))
+		if y < len(t.cells)-1 {
+			sb.WriteByte('\n')
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// Cursor returns the position of the cursor, in cells
+func (t *Terminal) Cursor() (x, y int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.x, t.y
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
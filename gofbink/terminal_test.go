@@ -0,0 +1,154 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// newTestTerminal returns a 10x15 terminal, on a virtual framebuffer
+func newTestTerminal(t *testing.T) (*Terminal, *VirtualFB) {
+	t.Helper()
+	f, v, cfg := newTestSession(t)
+	term := NewTerminal(f, &cfg)
+	if cols, rows := term.Size(); cols != 10 || rows != 15 {
+		t.Fatalf("size = %dx%d, want 10x15", cols, rows)
+	}
+	return term, v
+}
+
+func TestTerminalCSI(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+		x, y int
+	}{
+		{"CUP", "\x1b[3;5HX", "\n\n    X", 5, 2},
+		{"CUP defaults", "abc\x1b[HZ", "Zbc", 1, 0},
+		{"CUP clamps", "\x1b[99;99H", "", 9, 14},
+		{"CUP f", "\x1b[2;2fX", "\n X", 2, 1},
+		{"ED to the end", "aaaa\r\nbbbb\r\ncccc\x1b[2;3H\x1b[J", "aaaa\nbb", 2, 1},
+		{"ED from the start", "aaaa\r\nbbbb\r\ncccc\x1b[2;3H\x1b[1J", "\n   b\ncccc", 2, 1},
+		{"ED all", "aaaa\r\nbbbb\x1b[2J", "", 4, 1},
+		{"EL to the end", "abcdef\x1b[1;3H\x1b[K", "ab", 2, 0},
+		{"EL from the start", "abcdef\x1b[1;3H\x1b[1K", "   def", 2, 0},
+		{"EL all", "abcdef\x1b[2K", "", 6, 0},
+		{"DECSTBM scrolls the region only", "\x1b[5;1Hkeep\x1b[2;4r1\r\n2\r\n3\r\n4\r\n5", "1\n3\n4\n5\nkeep", 1, 3},
+		{"DECSTBM reverse index", "\x1b[2;4r\x1b[2;1Ha\r\nb\x1bM\x1bM", "\n\na\nb", 1, 1},
+		{"DECSTBM SD", "\x1b[2;3r\x1b[2;1Ha\r\nb\x1b[T", "\n\na", 1, 2},
+		{"DECSTBM reset", "\x1b[2;3r\x1b[r\x1b[15;1Hx\n", "\n\n\n\n\n\n\n\n\n\n\n\n\nx", 0, 14},
+		{"DECSC/DECRC", "\x1b[2;3H\x1b7\x1b[5;5Hx\x1b8y", "\n  y\n\n\n    x", 3, 1},
+		{"SCOSC/SCORC", "\x1b[3;3H\x1b[s\x1b[10;10H\x1b[uZ", "\n\n  Z", 3, 2},
+		{"wraps at the last column", "0123456789ab", "0123456789\nab", 2, 1},
+		{"split sequence", "\x1b[2", "", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			term, _ := newTestTerminal(t)
+			if _, err := term.Write([]byte(tt.in)); err != nil {
+				t.Fatal(err)
+			}
+			if got := term.String(); got != tt.want {
+				t.Errorf("screen = %q, want %q", got, tt.want)
+			}
+			if x, y := term.Cursor(); x != tt.x || y != tt.y {
+				t.Errorf("cursor = %d,%d, want %d,%d", x, y, tt.x, tt.y)
+			}
+		})
+	}
+}
+
+func TestTerminalSGR(t *testing.T) {
+	gray := func(r, g, b uint8) uint8 {
+		return color.GrayModel.Convert(color.RGBA{r, g, b, 0xFF}).(color.Gray).Y
+	}
+	tests := []struct {
+		name string
+		in   string
+		want termAttr
+	}{
+		{"bold inverse", "\x1b[1;7m", termAttr{bold: true, inverse: true}},
+		{"reset", "\x1b[1;7;0m", termAttr{}},
+		{"empty resets", "\x1b[1m\x1b[m", termAttr{}},
+		{"ANSI", "\x1b[31;107m", termAttr{fgSet: true, fg: xtermGray(1), bgSet: true, bg: 0xFF}},
+		{"default colors", "\x1b[31;41m\x1b[39;49m", termAttr{fg: xtermGray(1), bg: xtermGray(1)}},
+		{"256 fg", "\x1b[38;5;196m", termAttr{fgSet: true, fg: gray(255, 0, 0)}},
+		{"256 bg gray ramp", "\x1b[48;5;232m", termAttr{bgSet: true, bg: 8}},
+		{"256 cube", "\x1b[38;5;67m", termAttr{fgSet: true, fg: gray(95, 135, 175)}},
+		{"truecolor fg", "\x1b[38;2;255;0;0m", termAttr{fgSet: true, fg: gray(255, 0, 0)}},
+		{"truecolor bg", "\x1b[48;2;10;20;30m", termAttr{bgSet: true, bg: gray(10, 20, 30)}},
+		{"colon separators", "\x1b[38:2:0:0:255m", termAttr{fgSet: true, fg: gray(0, 0, 255)}},
+		{"followed by more", "\x1b[38;5;232;1m", termAttr{fgSet: true, fg: 8, bold: true}},
+		{"truncated", "\x1b[1m\x1b[38;5m", termAttr{bold: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			term, _ := newTestTerminal(t)
+			if _, err := term.Write([]byte(tt.in + "x")); err != nil {
+				t.Fatal(err)
+			}
+			if got := term.cells[0][0].attr; got != tt.want {
+				t.Errorf("attr = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerminalSaveRestoreAttr(t *testing.T) {
+	term, _ := newTestTerminal(t)
+	if _, err := term.Write([]byte("\x1b7\x1b[7m\x1b[2;1Hx\x1b8y")); err != nil {
+		t.Fatal(err)
+	}
+	if !term.cells[1][0].attr.inverse || term.cells[0][0].attr.inverse {
+		t.Errorf("attrs = %+v, %+v, want the saved one restored", term.cells[1][0].attr, term.cells[0][0].attr)
+	}
+}
+
+func TestTerminalDraw(t *testing.T) {
+	term, v := newTestTerminal(t)
+	v.ResetRefreshes()
+	if _, err := term.Write([]byte("ab\r\ncd")); err != nil {
+		t.Fatal(err)
+	}
+	// Both rows at once
+	checkRefreshes(t, v, fbRect(0, 0, 160, 32))
+	// The cursor is an inverted space, margins included
+	checkPixels(t, v.Image(), map[image.Point]uint8{
+		{2, 2}:   0x00,
+		{18, 18}: 0x00,
+		{32, 16}: 0x00,
+		{47, 31}: 0x00,
+		{48, 16}: 0xFF,
+		{32, 0}:  0xFF,
+	})
+	v.ResetRefreshes()
+	if _, err := term.Write([]byte("\x1b[?25l")); err != nil {
+		t.Fatal(err)
+	}
+	checkRefreshes(t, v, fbRect(0, 16, 160, 16))
+	checkPixels(t, v.Image(), map[image.Point]uint8{{32, 16}: 0xFF, {18, 18}: 0x00})
+}
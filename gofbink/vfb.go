@@ -0,0 +1,622 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"image"
+	"image/draw"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// VirtualFB is a pure Go Backend drawing to an in-memory 8bpp grayscale
+// framebuffer, for testing UI code against real pixel output on machines
+// that aren't eInk devices.
+// It follows libfbink's positioning rules (Row/Col, offsets, alignment,
+// margins...), but it doesn't ship any fonts: glyphs are drawn as solid
+// blocks filling their cell, which is enough to check what ends up where.
+// For the same reason, OT printing uses approximate metrics (glyphs are
+// half as wide as the font size, lines 1.25 times as tall).
+type VirtualFB struct {
+	mu        sync.Mutex
+	width     int // Native (i.e., unrotated) panel size
+	height    int
+	dpi       uint16
+	rota      uint8 // Current (canonical) rotation
+	pendRota  uint8 // Rotation to switch to on the next (re)init
+	img       *image.Gray
+	fg        uint8
+	bg        uint8
+	fontMult  int
+	centered  bool
+	grid      cellGrid
+	marker    uint32
+	lastRect  FBInkRect
+	refreshes []FBInkRect
+}
+
+// NewVirtualFB creates a virtual framebuffer for a panel of the given
+// native (portrait) size and DPI, in the given canonical rotation
+// (0: Upright, 1: Clockwise, 2: Upside down, 3: Counter clockwise).
+// Like a real device, it is blank (white) until something is drawn on it.
+func NewVirtualFB(width, height int, dpi uint16, rota uint8) *VirtualFB {
+	v := &VirtualFB{width: width, height: height, dpi: dpi, rota: rota & 3, pendRota: rota & 3}
+	v.fg, v.bg = FGblack.Gray().Y, BGwhite.Gray().Y
+	v.layout()
+	return v
+}
+
+// layout (re)creates the screen for the current rotation
+func (v *VirtualFB) layout() {
+	w, h := v.width, v.height
+	if v.rota&1 != 0 {
+		w, h = h, w
+	}
+	if v.img == nil || v.img.Rect.Dx() != w || v.img.Rect.Dy() != h {
+		v.img = image.NewGray(image.Rect(0, 0, w, h))
+		v.fill(v.img.Rect, v.bg)
+	}
+	v.grid = newCellGrid(v.img.Rect, v.fontMult, v.centered)
+}
+
+// Rotate simulates a rotation of the device, which is picked up by the
+// next Init or ReInit, much like what happens when Nickel or KOReader
+// rotate the framebuffer under our feet.
+func (v *VirtualFB) Rotate(rota uint8) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.pendRota = rota & 3
+}
+
+// Image returns a copy of the current contents of the framebuffer
+func (v *VirtualFB) Image() *image.Gray {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	img := image.NewGray(v.img.Rect)
+	copy(img.Pix, v.img.Pix)
+	return img
+}
+
+// Refreshes returns the regions refreshed so far, in order
+func (v *VirtualFB) Refreshes() []FBInkRect {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]FBInkRect(nil), v.refreshes...)
+}
+
+// ResetRefreshes forgets about the refreshes done so far
+func (v *VirtualFB) ResetRefreshes() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.refreshes = nil
+}
+
+// Open is a no-op, there's no device to open
+func (v *VirtualFB) Open() error {
+	return nil
+}
+
+// Close is a no-op, there's no device to close
+func (v *VirtualFB) Close() error {
+	return nil
+}
+
+// Init picks up the restricted options of cfg
+func (v *VirtualFB) Init(cfg *FBInkConfig) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.init(cfg)
+	return nil
+}
+
+func (v *VirtualFB) init(cfg *FBInkConfig) {
+	v.fontMult = int(cfg.fontmult)
+	if v.fontMult == 0 {
+		v.fontMult = ibmFontMult(v.dpi)
+	}
+	v.fg = cfg.fgColor.Gray().Y
+	v.bg = cfg.bgColor.Gray().Y
+	v.centered = cfg.isCentered
+	v.rota = v.pendRota
+	v.layout()
+}
+
+// ReInit reports (and applies) a rotation requested via Rotate
+func (v *VirtualFB) ReInit(cfg *FBInkConfig) (ReinitChange, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var change ReinitChange
+	if v.pendRota != v.rota {
+		change |= RotaChanged
+		if (v.pendRota^v.rota)&1 != 0 {
+			change |= LayoutChanged
+		}
+		v.init(cfg)
+	}
+	return change, nil
+}
+
+// State fills state with the virtual device's characteristics
+func (v *VirtualFB) State(cfg *FBInkConfig, state *FBInkState) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	cell := v.grid.cell
+	*state = FBInkState{
+		UserHZ:         100,
+		FontName:       "IBM",
+		ViewWidth:      uint32(v.img.Rect.Dx()),
+		ViewHeight:     uint32(v.img.Rect.Dy()),
+		ScreenWidth:    uint32(v.img.Rect.Dx()),
+		ScreenHeight:   uint32(v.img.Rect.Dy()),
+		BPP:            8,
+		DeviceName:     "Virtual",
+		DeviceCodename: "vfb",
+		DevicePlatform: "Go",
+		PenFGcolor:     v.fg,
+		PenBGcolor:     v.bg,
+		ScreenDPI:      v.dpi,
+		FontW:          uint16(cell),
+		FontH:          uint16(cell),
+		MaxCols:        uint16(v.grid.cols),
+		MaxRows:        uint16(v.grid.rows),
+		FontSizeMult:   uint8(v.fontMult),
+		GlyphWidth:     ibmGlyphSize,
+		GlyphHeight:    ibmGlyphSize,
+		IsPerfectFit:   v.img.Rect.Dx()%cell == 0 && v.img.Rect.Dy()%cell == 0,
+		CurrentRota:    v.rota,
+	}
+}
+
+// colors returns the fg/bg pen colors honoring IsInverted
+func (v *VirtualFB) colors(cfg *FBInkConfig) (fg, bg uint8) {
+	if cfg.IsInverted {
+		return v.bg, v.fg
+	}
+	return v.fg, v.bg
+}
+
+func (v *VirtualFB) fill(r image.Rectangle, c uint8) {
+	draw.Draw(v.img, r, uniform(c), image.ZP, draw.Src)
+}
+
+// damage updates the last rect, and refreshes it unless NoRefresh is set
+func (v *VirtualFB) damage(r image.Rectangle, cfg *FBInkConfig) {
+	r = r.Intersect(v.img.Rect)
+	v.lastRect = FBInkRect{Left: uint16(r.Min.X), Top: uint16(r.Min.Y), Width: uint16(r.Dx()), Height: uint16(r.Dy())}
+	if !cfg.NoRefresh && !r.Empty() {
+		v.refresh(r)
+	}
+}
+
+func (v *VirtualFB) refresh(r image.Rectangle) {
+	v.marker++
+	if v.marker == LastMarker {
+		v.marker++
+	}
+	v.refreshes = append(v.refreshes, FBInkRect{
+		Left:   uint16(r.Min.X),
+		Top:    uint16(r.Min.Y),
+		Width:  uint16(r.Dx()),
+		Height: uint16(r.Dy()),
+	})
+}
+
+// Print draws str on the grid, wrapping it as needed, and returns the
+// amount of rows it used
+func (v *VirtualFB) Print(str string, cfg *FBInkConfig) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if str == "" {
+		return int(eInval), createError("fbink_print", eInval)
+	}
+	if !utf8.ValidString(str) {
+		return int(eIlSeq), createError("fbink_print", eIlSeq)
+	}
+	fg, bg := v.colors(cfg)
+	if cfg.IsCleared {
+		v.fill(v.img.Rect, bg)
+	}
+	cell := v.grid.cell
+	col, row := v.grid.origin(cfg)
+	avail := v.grid.cols - col
+	if v.grid.centered {
+		avail = v.grid.cols
+	}
+	// Break the string into the lines that fit
+	var lines [][]rune
+	for _, para := range strings.Split(str, "\n") {
+		runes := []rune(para)
+		for len(runes) > avail {
+			lines = append(lines, runes[:avail])
+			runes = runes[avail:]
+		}
+		lines = append(lines, runes)
+	}
+	if len(lines) > v.grid.rows-row {
+		lines = lines[:v.grid.rows-row]
+	}
+	var dmg image.Rectangle
+	for i, line := range lines {
+		lineCol := col
+		if v.grid.centered {
+			lineCol = (v.grid.cols - len(line)) / 2
+		}
+		x := lineCol*cell + int(cfg.Hoffset)
+		y := (row+i)*cell + int(cfg.Voffset)
+		lineRect := image.Rect(x, y, x+len(line)*cell, y+cell)
+		if cfg.IsPadded {
+			lineRect.Min.X = int(cfg.Hoffset)
+			lineRect.Max.X = v.grid.cols*cell + int(cfg.Hoffset)
+		} else if cfg.IsRpadded {
+			lineRect.Max.X = v.grid.cols*cell + int(cfg.Hoffset)
+		}
+		if !cfg.IsBGless && !cfg.IsOverlay {
+			v.fill(lineRect, bg)
+		}
+		for j, r := range line {
+			if r == ' ' || cfg.isFGless {
+				continue
+			}
+			// Our "glyphs" are blocks with a one (font) pixel margin
+			m := v.fontMult
+			gx := x + j*cell
+			glyph := image.Rect(gx+m, y+m, gx+cell-m, y+cell-m)
+			if cfg.IsOverlay {
+				glyph = glyph.Intersect(v.img.Rect)
+				for py := glyph.Min.Y; py < glyph.Max.Y; py++ {
+					for px := glyph.Min.X; px < glyph.Max.X; px++ {
+						i := v.img.PixOffset(px, py)
+						v.img.Pix[i] ^= 0xFF
+					}
+				}
+			} else {
+				v.fill(glyph, fg)
+			}
+		}
+		dmg = dmg.Union(lineRect)
+	}
+	v.damage(dmg, cfg)
+	return len(lines), nil
+}
+
+// otArea returns the printable area defined by OT margins
+func (v *VirtualFB) otArea(otCfg *FBInkOTConfig) image.Rectangle {
+	w, h := v.img.Rect.Dx(), v.img.Rect.Dy()
+	edge := func(m int16, size int) int {
+		if m < 0 {
+			return size + int(m)
+		}
+		return int(m)
+	}
+	return image.Rect(
+		edge(otCfg.Margins.Left, w),
+		edge(otCfg.Margins.Top, h),
+		w-edge(otCfg.Margins.Right, w),
+		h-edge(otCfg.Margins.Bottom, h))
+}
+
+// PrintOT lays out str inside the margins, with approximate metrics, and
+// returns the new top margin (or 0 if there's no room left)
+func (v *VirtualFB) PrintOT(str string, otCfg *FBInkOTConfig, cfg *FBInkConfig) (int, FBInkOTFit, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fit := FBInkOTFit{}
+	if str == "" {
+		return int(eInval), fit, createError("fbink_print_ot", eInval)
+	}
+	if !utf8.ValidString(str) {
+		return int(eIlSeq), fit, createError("fbink_print_ot", eIlSeq)
+	}
+	area := v.otArea(otCfg)
+	if area.Empty() || !area.In(v.img.Rect) {
+		return int(eRange), fit, createError("fbink_print_ot", eRange)
+	}
+	size := int(otCfg.SizePx)
+	if size == 0 {
+		pt := otCfg.SizePt
+		if pt == 0 {
+			pt = 12
+		}
+		size = int(pt*float32(v.dpi)/72 + 0.5)
+	}
+	if size < 2 {
+		size = 2
+	}
+	glyphW, lineH := size/2, size+size/4
+	perLine := area.Dx() / glyphW
+	maxLines := area.Dy() / lineH
+	if perLine < 1 || maxLines < 1 {
+		fit.Truncated = true
+		return int(eNoSpc), fit, createError("fbink_print_ot", eNoSpc)
+	}
+	lines := wrapWords(str, perLine)
+	fit.ComputedLines = uint16(len(lines))
+	if len(lines) > maxLines {
+		fit.Truncated = true
+		if otCfg.NoTruncation {
+			return int(eNoSpc), fit, createError("fbink_print_ot", eNoSpc)
+		}
+		lines = lines[:maxLines]
+	}
+	if otCfg.ComputeOnly {
+		return 0, fit, nil
+	}
+	fg, bg := v.colors(cfg)
+	textH := len(lines) * lineH
+	dmg := image.Rect(area.Min.X, area.Min.Y, area.Max.X, area.Min.Y+textH)
+	switch otCfg.Padding {
+	case Full:
+		dmg = area
+	case Vertical:
+		dmg.Max.Y = area.Max.Y
+	}
+	if !cfg.IsBGless && !cfg.IsOverlay {
+		if otCfg.Padding != PaddingNone {
+			v.fill(dmg, bg)
+		} else {
+			for i, line := range lines {
+				x := v.otLineX(area, len(line), glyphW, otCfg)
+				y := area.Min.Y + i*lineH
+				v.fill(image.Rect(x, y, x+len(line)*glyphW, y+lineH), bg)
+			}
+		}
+	}
+	for i, line := range lines {
+		x := v.otLineX(area, len(line), glyphW, otCfg)
+		y := area.Min.Y + i*lineH
+		if otCfg.Padding == PaddingNone || otCfg.Padding == Vertical {
+			dmg = dmg.Union(image.Rect(x, y, x+len(line)*glyphW, y+lineH))
+		}
+		if cfg.isFGless {
+			continue
+		}
+		for j, r := range line {
+			if r == ' ' {
+				continue
+			}
+			gx := x + j*glyphW
+			v.fill(image.Rect(gx+1, y+lineH-size, gx+glyphW-1, y+lineH-1), fg)
+		}
+	}
+	fit.RenderedLines = uint16(len(lines))
+	v.damage(dmg, cfg)
+	top := area.Min.Y + textH
+	if top+lineH > area.Max.Y {
+		return 0, fit, nil
+	}
+	return top, fit, nil
+}
+
+func (v *VirtualFB) otLineX(area image.Rectangle, n, glyphW int, otCfg *FBInkOTConfig) int {
+	if otCfg.IsCentred {
+		return area.Min.X + (area.Dx()-n*glyphW)/2
+	}
+	return area.Min.X
+}
+
+// wrapWords breaks str into lines of at most width runes, at spaces when
+// possible
+func wrapWords(str string, width int) [][]rune {
+	var lines [][]rune
+	for _, para := range strings.Split(str, "\n") {
+		var line []rune
+		for _, word := range strings.Fields(para) {
+			w := []rune(word)
+			if len(line) > 0 && len(line)+1+len(w) <= width {
+				line = append(append(line, ' '), w...)
+				continue
+			}
+			if len(line) > 0 {
+				lines = append(lines, line)
+				line = nil
+			}
+			for len(w) > width {
+				lines = append(lines, w[:width])
+				w = w[width:]
+			}
+			line = w
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// drawImage composites src on screen
+func (v *VirtualFB) drawImage(src image.Image, x, y int16, cfg *FBInkConfig) {
+	b := src.Bounds()
+	p := v.grid.imageOrigin(v.img.Rect, b.Dx(), b.Dy(), x, y, cfg)
+	dst := image.Rectangle{Min: p, Max: p.Add(b.Size())}
+	if cfg.IsInverted {
+		src = invertImage(src)
+	}
+	draw.Draw(v.img, dst, src, b.Min, compositeOp(cfg))
+	v.damage(dst, cfg)
+}
+
+// PrintImage decodes an image file with the registered Go decoders,
+// and draws it
+func (v *VirtualFB) PrintImage(path string, x, y int16, cfg *FBInkConfig) error {
+	img, err := decodeImageFile(path)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.drawImage(img, x, y, cfg)
+	return nil
+}
+
+// PrintRawData draws packed Y, YA, RGB or RGBA scanlines
+func (v *VirtualFB) PrintRawData(data []byte, w, h int, x, y int16, cfg *FBInkConfig) error {
+	img, err := rawImage(data, w, h)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.drawImage(img, x, y, cfg)
+	return nil
+}
+
+// ClearScreen paints rect (or the whole screen) in the background color
+func (v *VirtualFB) ClearScreen(cfg *FBInkConfig, rect *FBInkRect) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	r := v.img.Rect
+	if rect != nil && rect.Width != 0 && rect.Height != 0 {
+		r = image.Rect(int(rect.Left), int(rect.Top), int(rect.Left)+int(rect.Width), int(rect.Top)+int(rect.Height))
+	}
+	_, bg := v.colors(cfg)
+	v.fill(r, bg)
+	v.damage(r, cfg)
+	return nil
+}
+
+// GridClear paints a block of cells in the background color
+func (v *VirtualFB) GridClear(cols, rows uint16, cfg *FBInkConfig) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	r := v.grid.rect(int(cols), int(rows), cfg)
+	if !cfg.IsBGless {
+		_, bg := v.colors(cfg)
+		v.fill(r, bg)
+	}
+	v.damage(r, cfg)
+	return nil
+}
+
+// Refresh records a refresh of the given region (or of the whole screen
+// if it's empty)
+func (v *VirtualFB) Refresh(top, left, width, height uint32, cfg *FBInkConfig) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	r := image.Rect(int(left), int(top), int(left+width), int(top+height))
+	if width == 0 && height == 0 && top == 0 && left == 0 {
+		r = v.img.Rect
+	}
+	v.refresh(r.Intersect(v.img.Rect))
+	return nil
+}
+
+// GridRefresh records a refresh of a block of cells
+func (v *VirtualFB) GridRefresh(cols, rows uint16, cfg *FBInkConfig) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.refresh(v.grid.rect(int(cols), int(rows), cfg).Intersect(v.img.Rect))
+	return nil
+}
+
+func (v *VirtualFB) wait(op string, marker uint32) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if marker == LastMarker && v.marker == LastMarker {
+		return createError(op, eInval)
+	}
+	// Our refreshes are instantaneous
+	return nil
+}
+
+// WaitForSubmission returns immediately, as refreshes are instantaneous
+func (v *VirtualFB) WaitForSubmission(marker uint32) error {
+	return v.wait("fbink_wait_for_submission", marker)
+}
+
+// WaitForCompletion returns immediately, as refreshes are instantaneous
+func (v *VirtualFB) WaitForCompletion(marker uint32) error {
+	return v.wait("fbink_wait_for_complete", marker)
+}
+
+// LastMarker returns the marker of the last refresh
+func (v *VirtualFB) LastMarker() uint32 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.marker
+}
+
+// LastRect returns the last drawn area
+func (v *VirtualFB) LastRect() FBInkRect {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.lastRect
+}
+
+func (v *VirtualFB) dump(r image.Rectangle, isFull bool) *FBInkDump {
+	data := make([]byte, r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		copy(data[(y-r.Min.Y)*r.Dx():], v.img.Pix[v.img.PixOffset(r.Min.X, y):v.img.PixOffset(r.Max.X, y)])
+	}
+	return NewDump(data, r.Dx(), r, v.rota, 8, isFull)
+}
+
+// Dump takes a snapshot of the whole screen
+func (v *VirtualFB) Dump() (*FBInkDump, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.dump(v.img.Rect, true), nil
+}
+
+// RegionDump takes a snapshot of part of the screen, positioned like an image
+func (v *VirtualFB) RegionDump(x, y int16, w, h uint16, cfg *FBInkConfig) (*FBInkDump, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	p := v.grid.imageOrigin(v.img.Rect, int(w), int(h), x, y, cfg)
+	r := image.Rectangle{Min: p, Max: p.Add(image.Pt(int(w), int(h)))}.Intersect(v.img.Rect)
+	if r.Empty() {
+		return nil, createError("fbink_region_dump", eInval)
+	}
+	return v.dump(r, false), nil
+}
+
+// Restore puts a dump back where it was taken from, honoring its Clip
+func (v *VirtualFB) Restore(dump *FBInkDump, cfg *FBInkConfig) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	data, ok := dump.data.(goDumpData)
+	if dump.data == nil {
+		return createError("fbink_restore", eInval)
+	}
+	if !ok || dump.BPP != 8 || dump.Rota != v.rota {
+		return createError("fbink_restore", eNotSup)
+	}
+	area := image.Rect(int(dump.Area.Left), int(dump.Area.Top), int(dump.Area.Left)+int(dump.Area.Width), int(dump.Area.Top)+int(dump.Area.Height))
+	if !area.In(v.img.Rect) {
+		return createError("fbink_restore", eNotSup)
+	}
+	r := area
+	if dump.Clip.Width != 0 && dump.Clip.Height != 0 {
+		clip := image.Rect(int(dump.Clip.Left), int(dump.Clip.Top), int(dump.Clip.Left)+int(dump.Clip.Width), int(dump.Clip.Top)+int(dump.Clip.Height))
+		if !clip.In(v.img.Rect) || !clip.Overlaps(area) {
+			return createError("fbink_restore", eNotSup)
+		}
+		r = area.Intersect(clip)
+	}
+	stride := int(dump.Stride)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		src := data[(y-area.Min.Y)*stride+(r.Min.X-area.Min.X):]
+		copy(v.img.Pix[v.img.PixOffset(r.Min.X, y):v.img.PixOffset(r.Max.X, y)], src)
+	}
+	v.damage(r, cfg)
+	return nil
+}
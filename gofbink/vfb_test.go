@@ -0,0 +1,397 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"errors"
+	"image"
+	"testing"
+)
+
+// The test screen is 160x240 at 150 DPI, i.e., a 10x15 grid of 16px cells
+func newTestVFB(t *testing.T) (*VirtualFB, *FBInkConfig) {
+	t.Helper()
+	v := NewVirtualFB(160, 240, 150, 0)
+	cfg := &FBInkConfig{}
+	if err := v.Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return v, cfg
+}
+
+func fbRect(x, y, w, h int) FBInkRect {
+	return FBInkRect{Left: uint16(x), Top: uint16(y), Width: uint16(w), Height: uint16(h)}
+}
+
+// checkPixels checks the gray level of a few pixels
+func checkPixels(t *testing.T, img *image.Gray, want map[image.Point]uint8) {
+	t.Helper()
+	for p, y := range want {
+		if got := img.GrayAt(p.X, p.Y).Y; got != y {
+			t.Errorf("pixel %v = %#x, want %#x", p, got, y)
+		}
+	}
+}
+
+func checkRefreshes(t *testing.T, v *VirtualFB, want ...FBInkRect) {
+	t.Helper()
+	got := v.Refreshes()
+	if len(got) != len(want) {
+		t.Fatalf("refreshes = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("refresh %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestVirtualFBPrint(t *testing.T) {
+	tests := []struct {
+		name   string
+		str    string
+		row    int16
+		col    int16
+		rows   int
+		pixels map[image.Point]uint8
+		dmg    FBInkRect
+	}{
+		{
+			name: "single line",
+			str:  "A B",
+			row:  1,
+			col:  2,
+			rows: 1,
+			pixels: map[image.Point]uint8{
+				{34, 18}: 0x00, // Inside the first glyph
+				{32, 16}: 0xFF, // Its margin
+				{50, 18}: 0xFF, // The space
+				{66, 18}: 0x00,
+				{34, 34}: 0xFF, // Next row
+			},
+			dmg: fbRect(32, 16, 48, 16),
+		},
+		{
+			name: "wrapped",
+			str:  "ABCDEFGHIJKL",
+			row:  3,
+			rows: 2,
+			pixels: map[image.Point]uint8{
+				{146, 50}: 0x00, // Last column
+				{18, 66}:  0x00, // Wrapped to the next row
+				{34, 66}:  0xFF,
+			},
+			dmg: fbRect(0, 48, 160, 32),
+		},
+		{
+			name: "truncated at the bottom",
+			str:  "A\nB\nC",
+			row:  -2,
+			rows: 2,
+			pixels: map[image.Point]uint8{
+				{2, 210}: 0x00,
+				{2, 226}: 0x00,
+			},
+			dmg: fbRect(0, 208, 16, 32),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, cfg := newTestVFB(t)
+			cfg.Row, cfg.Col = tt.row, tt.col
+			rows, err := v.Print(tt.str, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rows != tt.rows {
+				t.Errorf("rows = %d, want %d", rows, tt.rows)
+			}
+			checkPixels(t, v.Image(), tt.pixels)
+			checkRefreshes(t, v, tt.dmg)
+			if got := v.LastRect(); got != tt.dmg {
+				t.Errorf("last rect = %v, want %v", got, tt.dmg)
+			}
+		})
+	}
+}
+
+func TestVirtualFBPrintErrors(t *testing.T) {
+	v, cfg := newTestVFB(t)
+	if _, err := v.Print("", cfg); !errors.Is(err, ErrInvalid) {
+		t.Errorf("empty string: err = %v, want ErrInvalid", err)
+	}
+	if _, err := v.Print("\xff", cfg); !errors.Is(err, ErrIllegalSequence) {
+		t.Errorf("invalid UTF-8: err = %v, want ErrIllegalSequence", err)
+	}
+	checkRefreshes(t, v)
+}
+
+func TestVirtualFBNoRefresh(t *testing.T) {
+	v, cfg := newTestVFB(t)
+	cfg.NoRefresh = true
+	if _, err := v.Print("A", cfg); err != nil {
+		t.Fatal(err)
+	}
+	checkPixels(t, v.Image(), map[image.Point]uint8{{2, 2}: 0x00})
+	checkRefreshes(t, v)
+	if got, want := v.LastRect(), fbRect(0, 0, 16, 16); got != want {
+		t.Errorf("last rect = %v, want %v", got, want)
+	}
+}
+
+func TestVirtualFBClearScreen(t *testing.T) {
+	v, cfg := newTestVFB(t)
+	// Inverted, the background is black
+	cfg.IsInverted = true
+	if err := v.ClearScreen(cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkPixels(t, v.Image(), map[image.Point]uint8{{0, 0}: 0x00, {159, 239}: 0x00})
+	cfg.IsInverted = false
+	if err := v.ClearScreen(cfg, &FBInkRect{Left: 10, Top: 20, Width: 30, Height: 40}); err != nil {
+		t.Fatal(err)
+	}
+	checkPixels(t, v.Image(), map[image.Point]uint8{
+		{10, 20}: 0xFF,
+		{39, 59}: 0xFF,
+		{9, 20}:  0x00,
+		{40, 59}: 0x00,
+		{39, 60}: 0x00,
+	})
+	// An empty rect clears the whole screen
+	if err := v.ClearScreen(cfg, &FBInkRect{Left: 10, Top: 20}); err != nil {
+		t.Fatal(err)
+	}
+	checkPixels(t, v.Image(), map[image.Point]uint8{{0, 0}: 0xFF, {159, 239}: 0xFF})
+	checkRefreshes(t, v, fbRect(0, 0, 160, 240), fbRect(10, 20, 30, 40), fbRect(0, 0, 160, 240))
+}
+
+func TestVirtualFBGrid(t *testing.T) {
+	v, cfg := newTestVFB(t)
+	cfg.Row, cfg.Col = 1, 1
+	cfg.IsInverted = true
+	if err := v.GridClear(3, 2, cfg); err != nil {
+		t.Fatal(err)
+	}
+	checkPixels(t, v.Image(), map[image.Point]uint8{
+		{16, 16}: 0x00,
+		{63, 47}: 0x00,
+		{15, 16}: 0xFF,
+		{64, 16}: 0xFF,
+		{16, 48}: 0xFF,
+	})
+	// BGless clears only refresh
+	cfg.IsInverted = false
+	cfg.IsBGless = true
+	if err := v.GridClear(1, 1, cfg); err != nil {
+		t.Fatal(err)
+	}
+	checkPixels(t, v.Image(), map[image.Point]uint8{{16, 16}: 0x00})
+	before := v.Image()
+	cfg.Row, cfg.Col = 2, 3
+	if err := v.GridRefresh(2, 1, cfg); err != nil {
+		t.Fatal(err)
+	}
+	// Refreshes are clipped to the screen
+	cfg.Row, cfg.Col = -1, -1
+	if err := v.GridRefresh(4, 4, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if after := v.Image(); string(after.Pix) != string(before.Pix) {
+		t.Error("GridRefresh changed the screen")
+	}
+	checkRefreshes(t, v,
+		fbRect(16, 16, 48, 32),
+		fbRect(16, 16, 16, 16),
+		fbRect(48, 32, 32, 16),
+		fbRect(144, 224, 16, 16))
+}
+
+func TestVirtualFBPrintRawData(t *testing.T) {
+	black := make([]byte, 8*8)
+	tests := []struct {
+		name   string
+		x, y   int16
+		pixels map[image.Point]uint8
+		dmg    FBInkRect
+	}{
+		{
+			name:   "inside",
+			x:      20,
+			y:      30,
+			pixels: map[image.Point]uint8{{20, 30}: 0x00, {27, 37}: 0x00, {28, 37}: 0xFF},
+			dmg:    fbRect(20, 30, 8, 8),
+		},
+		{
+			name:   "bottom right edge",
+			x:      156,
+			y:      236,
+			pixels: map[image.Point]uint8{{156, 236}: 0x00, {159, 239}: 0x00, {155, 236}: 0xFF},
+			dmg:    fbRect(156, 236, 4, 4),
+		},
+		{
+			name:   "top left edge",
+			x:      -4,
+			y:      -6,
+			pixels: map[image.Point]uint8{{0, 0}: 0x00, {3, 1}: 0x00, {4, 0}: 0xFF, {0, 2}: 0xFF},
+			dmg:    fbRect(0, 0, 4, 2),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, cfg := newTestVFB(t)
+			if err := v.PrintRawData(black, 8, 8, tt.x, tt.y, cfg); err != nil {
+				t.Fatal(err)
+			}
+			checkPixels(t, v.Image(), tt.pixels)
+			checkRefreshes(t, v, tt.dmg)
+		})
+	}
+	t.Run("off screen", func(t *testing.T) {
+		v, cfg := newTestVFB(t)
+		if err := v.PrintRawData(black, 8, 8, 200, 0, cfg); err != nil {
+			t.Fatal(err)
+		}
+		checkRefreshes(t, v)
+	})
+	t.Run("bad size", func(t *testing.T) {
+		v, cfg := newTestVFB(t)
+		if err := v.PrintRawData(black[:10], 8, 8, 0, 0, cfg); !errors.Is(err, ErrInvalid) {
+			t.Errorf("err = %v, want ErrInvalid", err)
+		}
+	})
+}
+
+func TestVirtualFBDumpRestore(t *testing.T) {
+	v, cfg := newTestVFB(t)
+	if _, err := v.Print("Hello\nWorld", cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := v.Image()
+
+	dump, err := v.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dump.IsFull || dump.Area != fbRect(0, 0, 160, 240) {
+		t.Errorf("dump area = %v (full: %v)", dump.Area, dump.IsFull)
+	}
+	if err := v.ClearScreen(cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	v.ResetRefreshes()
+	if err := v.Restore(dump, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.Image(); string(got.Pix) != string(want.Pix) {
+		t.Error("full dump wasn't restored")
+	}
+	checkRefreshes(t, v, fbRect(0, 0, 160, 240))
+
+	region, err := v.RegionDump(0, 0, 32, 32, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if region.IsFull || region.Area != fbRect(0, 0, 32, 32) {
+		t.Errorf("region area = %v (full: %v)", region.Area, region.IsFull)
+	}
+	if err := v.ClearScreen(cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Only restore the part of the region inside the clip
+	region.Clip = fbRect(16, 16, 100, 100)
+	v.ResetRefreshes()
+	if err := v.Restore(region, cfg); err != nil {
+		t.Fatal(err)
+	}
+	checkPixels(t, v.Image(), map[image.Point]uint8{
+		{2, 2}:   0xFF, // "H", clipped
+		{18, 2}:  0xFF, // "e", clipped
+		{2, 18}:  0xFF, // "W", clipped
+		{18, 18}: 0x00, // "o", restored
+		{34, 18}: 0xFF, // "r", out of the region
+	})
+	checkRefreshes(t, v, fbRect(16, 16, 16, 16))
+
+	region.Clip = fbRect(100, 100, 10, 10)
+	if err := v.Restore(region, cfg); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("clip outside of the dump: err = %v, want ErrNotSupported", err)
+	}
+	if _, err := v.RegionDump(200, 0, 10, 10, cfg); !errors.Is(err, ErrInvalid) {
+		t.Errorf("region off screen: err = %v, want ErrInvalid", err)
+	}
+	dump.Free()
+	if err := v.Restore(dump, cfg); !errors.Is(err, ErrInvalid) {
+		t.Errorf("freed dump: err = %v, want ErrInvalid", err)
+	}
+}
+
+func TestVirtualFBRotate(t *testing.T) {
+	v, cfg := newTestVFB(t)
+	dump, err := v.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		rota   uint8
+		change ReinitChange
+		w, h   int
+		cols   uint16
+		rows   uint16
+	}{
+		{0, 0, 160, 240, 10, 15},
+		{1, RotaChanged | LayoutChanged, 240, 160, 15, 10},
+		{3, RotaChanged, 240, 160, 15, 10},
+		{2, RotaChanged | LayoutChanged, 160, 240, 10, 15},
+	}
+	for _, s := range steps {
+		v.Rotate(s.rota)
+		change, err := v.ReInit(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if change != s.change {
+			t.Errorf("rota %d: change = %v, want %v", s.rota, change, s.change)
+		}
+		var state FBInkState
+		v.State(cfg, &state)
+		if b := v.Image().Bounds(); b.Dx() != s.w || b.Dy() != s.h {
+			t.Errorf("rota %d: screen = %v, want %dx%d", s.rota, b, s.w, s.h)
+		}
+		if state.CurrentRota != s.rota || state.MaxCols != s.cols || state.MaxRows != s.rows {
+			t.Errorf("rota %d: state rota %d, grid %dx%d, want %dx%d",
+				s.rota, state.CurrentRota, state.MaxCols, state.MaxRows, s.cols, s.rows)
+		}
+	}
+	// Dumps are tied to the rotation they were taken in
+	if err := v.Restore(dump, cfg); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("restore in another rotation: err = %v, want ErrNotSupported", err)
+	}
+	// Printing follows the new layout
+	cfg.Row, cfg.Col = -1, -1
+	if _, err := v.Print("A", cfg); err != nil {
+		t.Fatal(err)
+	}
+	checkPixels(t, v.Image(), map[image.Point]uint8{{146, 226}: 0x00})
+}
@@ -0,0 +1,145 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"sync"
+	"time"
+)
+
+// ReinitEvent describes a framebuffer change picked up by a Watcher
+type ReinitEvent struct {
+	Change  ReinitChange
+	OldRota uint8 // Canonical rotations
+	NewRota uint8
+	OldBPP  uint32
+	NewBPP  uint32
+	State   FBInkState // The state after the change
+}
+
+// Watcher reinitializes an FBInk session when the framebuffer changes
+// underneath it (eg: when Nickel or KOReader rotate the screen, or switch
+// bitdepth), and publishes a ReinitEvent for every change, so that layouts
+// can be rebuilt. ReInit calls made by the session's users are reported too.
+type Watcher struct {
+	f          *FBInk
+	beforeDraw bool
+	state      FBInkState
+	events     chan ReinitEvent
+	stop       chan struct{}
+	stopped    chan struct{}
+	stopOnce   sync.Once
+}
+
+// Watch starts watching for framebuffer changes, replacing any previous
+// Watcher of the session.
+// With a non-zero interval, the framebuffer is polled in the background.
+// Otherwise, it is checked before every queued call of the session, which
+// catches changes just before drawing, at the cost of a ReInit every time.
+// Events are buffered: if nobody keeps up with them, the oldest ones are
+// dropped, as the latest State is what matters.
+func (f *FBInk) Watch(interval time.Duration) *Watcher {
+	w := &Watcher{
+		f:          f,
+		beforeDraw: interval == 0,
+		events:     make(chan ReinitEvent, 8),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	var prev *Watcher
+	f.do(func() {
+		prev = f.watcher
+		f.backend.State(&f.internCfg, &w.state)
+	})
+	if prev != nil {
+		prev.Stop()
+	}
+	f.do(func() { f.watcher = w })
+	if interval == 0 {
+		close(w.stopped)
+		return w
+	}
+	go func() {
+		defer close(w.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f.do(w.check)
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// Events returns the channel the events are published on. It is closed by Stop.
+func (w *Watcher) Events() <-chan ReinitEvent {
+	return w.events
+}
+
+// Stop stops watching, and closes the Events channel
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+		<-w.stopped
+		w.f.do(func() {
+			if w.f.watcher == w {
+				w.f.watcher = nil
+			}
+			close(w.events)
+		})
+	})
+}
+
+// check calls ReInit, and publishes what changed, if anything.
+// It runs on the render goroutine. A failed ReInit is simply retried on
+// the next check.
+func (w *Watcher) check() {
+	change, err := w.f.backend.ReInit(&w.f.internCfg)
+	if err == nil && change.Changed() {
+		w.publish(change)
+	}
+}
+
+// publish sends an event, dropping the oldest pending one if need be.
+// It runs on the render goroutine.
+func (w *Watcher) publish(change ReinitChange) {
+	ev := ReinitEvent{Change: change, OldRota: w.state.CurrentRota, OldBPP: w.state.BPP}
+	w.f.backend.State(&w.f.internCfg, &w.state)
+	ev.NewRota, ev.NewBPP, ev.State = w.state.CurrentRota, w.state.BPP, w.state
+	for {
+		select {
+		case w.events <- ev:
+			return
+		default:
+			select {
+			case <-w.events:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"strings"
+	"unicode"
+)
+
+// eastAsianWide covers the common East Asian Wide & Fullwidth ranges
+// (CJK, Hangul, Kana, fullwidth forms, emoji), which take two cells
+var eastAsianWide = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x1100, 0x115F, 1},
+		{0x231A, 0x231B, 1},
+		{0x2329, 0x232A, 1},
+		{0x23E9, 0x23EC, 1},
+		{0x23F0, 0x23F0, 1},
+		{0x23F3, 0x23F3, 1},
+		{0x25FD, 0x25FE, 1},
+		{0x2614, 0x2615, 1},
+		{0x2648, 0x2653, 1},
+		{0x267F, 0x267F, 1},
+		{0x2693, 0x2693, 1},
+		{0x26A1, 0x26A1, 1},
+		{0x26AA, 0x26AB, 1},
+		{0x26BD, 0x26BE, 1},
+		{0x26C4, 0x26C5, 1},
+		{0x26CE, 0x26CE, 1},
+		{0x26D4, 0x26D4, 1},
+		{0x26EA, 0x26EA, 1},
+		{0x26F2, 0x26F3, 1},
+		{0x26F5, 0x26F5, 1},
+		{0x26FA, 0x26FA, 1},
+		{0x26FD, 0x26FD, 1},
+		{0x2705, 0x2705, 1},
+		{0x270A, 0x270B, 1},
+		{0x2728, 0x2728, 1},
+		{0x274C, 0x274C, 1},
+		{0x274E, 0x274E, 1},
+		{0x2753, 0x2755, 1},
+		{0x2757, 0x2757, 1},
+		{0x2795, 0x2797, 1},
+		{0x27B0, 0x27B0, 1},
+		{0x27BF, 0x27BF, 1},
+		{0x2B1B, 0x2B1C, 1},
+		{0x2B50, 0x2B50, 1},
+		{0x2B55, 0x2B55, 1},
+		{0x2E80, 0x303E, 1},
+		{0x3041, 0x33FF, 1},
+		{0x3400, 0x4DBF, 1},
+		{0x4E00, 0x9FFF, 1},
+		{0xA000, 0xA4CF, 1},
+		{0xA960, 0xA97F, 1},
+		{0xAC00, 0xD7A3, 1},
+		{0xF900, 0xFAFF, 1},
+		{0xFE10, 0xFE19, 1},
+		{0xFE30, 0xFE6F, 1},
+		{0xFF00, 0xFF60, 1},
+		{0xFFE0, 0xFFE6, 1},
+	},
+	R32: []unicode.Range32{
+		{0x16FE0, 0x16FE4, 1},
+		{0x17000, 0x18AFF, 1},
+		{0x1B000, 0x1B2FF, 1},
+		{0x1F004, 0x1F004, 1},
+		{0x1F0CF, 0x1F0CF, 1},
+		{0x1F18E, 0x1F18E, 1},
+		{0x1F191, 0x1F19A, 1},
+		{0x1F200, 0x1F251, 1},
+		{0x1F300, 0x1F64F, 1},
+		{0x1F680, 0x1F6FF, 1},
+		{0x1F900, 0x1F9FF, 1},
+		{0x20000, 0x2FFFD, 1},
+		{0x30000, 0x3FFFD, 1},
+	},
+}
+
+// runeWidth returns the amount of cells r takes on a fixed cell display:
+// 0 for combining marks, zero-width & control characters, 2 for East Asian
+// Wide & Fullwidth characters, and 1 otherwise.
+// With dwFont set (i.e., with the UnifontDW font, whose cells are already
+// double-width), every visible character takes a single cell.
+func runeWidth(r rune, dwFont bool) int {
+	switch {
+	case r < 0x20 || (r >= 0x7F && r < 0xA0):
+		return 0
+	case r < 0x300:
+		// Fast path for Latin
+		return 1
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case !dwFont && unicode.Is(eastAsianWide, r):
+		return 2
+	}
+	return 1
+}
+
+// stringWidth returns the amount of cells s takes on a fixed cell display
+func stringWidth(s string, dwFont bool) int {
+	w := 0
+	for _, r := range s {
+		w += runeWidth(r, dwFont)
+	}
+	return w
+}
+
+// cellText converts s to what fbink_print needs to draw it cell by cell:
+// fbink_print draws every codepoint in its own cell, so zero width
+// characters are dropped, and wide ones are followed by a blank cell.
+func cellText(s string, dwFont bool) string {
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		switch runeWidth(r, dwFont) {
+		case 1:
+			out = append(out, r)
+		case 2:
+			out = append(out, r, ' ')
+		}
+	}
+	return string(out)
+}
+
+// wrapCells breaks a line into rows of at most cols cells, at spaces or
+// around wide characters (as CJK text doesn't use spaces) when possible,
+// breaking longer words wherever needed. Spaces at the start of wrapped
+// rows, and at the end of rows, are dropped.
+func wrapCells(line string, cols int, dwFont bool) []string {
+	var rows []string
+	var cur []rune
+	curW := 0
+	emit := func() {
+		for len(cur) > 0 && cur[len(cur)-1] == ' ' {
+			cur = cur[:len(cur)-1]
+		}
+		rows = append(rows, string(cur))
+		cur, curW = nil, 0
+	}
+	runes := []rune(line)
+	for i := 0; i < len(runes); {
+		// Grab the next token: a run of spaces, a word, or a wide character,
+		// along with the zero-width characters that follow it
+		j := i + 1
+		w := runeWidth(runes[i], dwFont)
+		switch {
+		case runes[i] == ' ':
+			for j < len(runes) && runes[j] == ' ' {
+				j++
+			}
+		case w < 2:
+			for j < len(runes) && runes[j] != ' ' && runeWidth(runes[j], dwFont) < 2 {
+				j++
+			}
+		default:
+			for j < len(runes) && runeWidth(runes[j], dwFont) == 0 {
+				j++
+			}
+		}
+		token := runes[i:j]
+		i = j
+		tw := 0
+		for _, r := range token {
+			tw += runeWidth(r, dwFont)
+		}
+		if token[0] == ' ' {
+			if curW == 0 && len(rows) > 0 {
+				continue
+			}
+			if curW+tw > cols {
+				if curW > 0 {
+					emit()
+				}
+				continue
+			}
+		} else if curW+tw > cols {
+			if strings.TrimLeft(string(cur), " ") != "" {
+				emit()
+			} else if tw <= cols {
+				// Drop indentation that would push the word off the row
+				cur, curW = nil, 0
+			}
+		}
+		// Hard break tokens that don't fit on a row of their own
+		for _, r := range token {
+			rw := runeWidth(r, dwFont)
+			if curW+rw > cols && curW > 0 {
+				emit()
+			}
+			cur = append(cur, r)
+			curW += rw
+		}
+	}
+	if cur != nil || len(rows) == 0 {
+		emit()
+	}
+	return rows
+}
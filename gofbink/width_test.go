@@ -0,0 +1,116 @@
+/*
+	FBInk: FrameBuffer eInker, a tool to print text & images on eInk devices (Kobo/Kindle)
+	Copyright (C) 2018-2019 NiLuJe <ninuje@gmail.com>
+
+	go-fbink: A Go wrapper for FBInk
+	Copyright (C) 2018-2019 Sherman Perry
+
+	----
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as
+	published by the Free Software Foundation, either version 3 of the
+	License, or (at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gofbink
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRuneWidth(t *testing.T) {
+	tests := []struct {
+		r      rune
+		dwFont bool
+		want   int
+	}{
+		{'a', false, 1},
+		{'\t', false, 0},
+		{0x7F, false, 0},
+		{0x9F, false, 0},
+		{'é', false, 1},
+		{0x0301, false, 0}, // Combining acute accent
+		{0x20DD, false, 0}, // Combining enclosing circle
+		{0x200B, false, 0}, // Zero width space
+		{'中', false, 2},
+		{'ア', false, 2},
+		{'한', false, 2},
+		{'Ａ', false, 2}, // Fullwidth
+		{'ｱ', false, 1}, // Halfwidth
+		{'😀', false, 2},
+		{0x20000, false, 2}, // CJK Extension B
+		{'中', true, 1},
+		{0x0301, true, 0},
+	}
+	for _, tt := range tests {
+		if got := runeWidth(tt.r, tt.dwFont); got != tt.want {
+			t.Errorf("runeWidth(%U, %v) = %d, want %d", tt.r, tt.dwFont, got, tt.want)
+		}
+	}
+}
+
+func TestCellText(t *testing.T) {
+	if got, want := cellText("中a\u0301\u200bb", false), "中 ab"; got != want {
+		t.Errorf("cellText = %q, want %q", got, want)
+	}
+	if got, want := cellText("中文", true), "中文"; got != want {
+		t.Errorf("cellText (dw) = %q, want %q", got, want)
+	}
+	if got, want := stringWidth("中a\u0301", false), 3; got != want {
+		t.Errorf("stringWidth = %d, want %d", got, want)
+	}
+}
+
+func TestWrapCells(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		cols   int
+		dwFont bool
+		want   []string
+	}{
+		{"empty", "", 4, false, []string{""}},
+		{"fits", "ab cd", 5, false, []string{"ab cd"}},
+		{"at spaces", "hello world", 5, false, []string{"hello", "world"}},
+		{"trailing spaces", "ab   ", 4, false, []string{"ab"}},
+		{"leading spaces", "  ab", 4, false, []string{"  ab"}},
+		{"indentation pushing a word off", "  abcd", 4, false, []string{"abcd"}},
+		{"CJK", "中文字", 4, false, []string{"中文", "字"}},
+		{"fullwidth", "ＡＢＣ", 5, false, []string{"ＡＢ", "Ｃ"}},
+		{"wide rune in the last column", "ab中", 4, false, []string{"ab中"}},
+		{"wide rune past the last column", "abc中", 4, false, []string{"abc", "中"}},
+		{"wide rune after spaces", "ab 中", 4, false, []string{"ab", "中"}},
+		{"combining marks", "e\u0301e\u0301", 2, false, []string{"e\u0301e\u0301"}},
+		{"combining marks stay with their base", "e\u0301e\u0301", 1, false, []string{"e\u0301", "e\u0301"}},
+		{"combining mark on a wide rune", "中\u0301x", 2, false, []string{"中\u0301", "x"}},
+		{"long word", "abcdefghij", 4, false, []string{"abcd", "efgh", "ij"}},
+		{"long word after text", "ab abcdefgh", 4, false, []string{"ab", "abcd", "efgh"}},
+		{"width 1", "ab c", 1, false, []string{"a", "b", "c"}},
+		{"width 1 wide rune", "中a", 1, false, []string{"中", "a"}},
+		{"double width font", "中文字", 2, true, []string{"中文", "字"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := wrapCells(tt.line, tt.cols, tt.dwFont)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrapCells(%q, %d) = %q, want %q", tt.line, tt.cols, got, tt.want)
+			}
+			for _, row := range got {
+				// Only a wide rune on its own may not fit a single cell
+				if w := stringWidth(row, tt.dwFont); w > tt.cols && tt.cols > 1 {
+					t.Errorf("row %q is %d cells wide", row, w)
+				}
+			}
+		})
+	}
+}